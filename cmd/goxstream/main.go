@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"goxstream/internal/api"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
@@ -12,10 +14,13 @@ import (
 
 func main() {
 	killProcess()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	fmt.Println("GoXStream REST API running on :8080")
-	if err := api.StartAPIServer(":8080"); err != nil {
+	if err := api.StartAPIServer(ctx, ":8080"); err != nil {
 		panic(err)
 	}
+	fmt.Println("GoXStream stopped")
 }
 
 func killProcess() {
@@ -3,8 +3,8 @@ module goxstream
 go 1.24.4
 
 require (
-	github.com/klauspost/compress v1.15.9 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	github.com/pierrec/lz4/v4 v4.1.15 // indirect
-	github.com/segmentio/kafka-go v0.4.48 // indirect
+	github.com/klauspost/compress v1.15.9
+	github.com/lib/pq v1.10.9
+	github.com/pierrec/lz4/v4 v4.1.15
+	github.com/segmentio/kafka-go v0.4.48
 )
@@ -12,15 +12,18 @@ import {
 const operatorFields = {
   map: [
     { name: "col", label: "Column", type: "text" },
-    { name: "val", label: "Value", type: "text" }
+    { name: "val", label: "Value", type: "text" },
+    { name: "expr", label: "Expression (e.g. score * 1.1)", type: "text" }
   ],
   filter: [
     { name: "field", label: "Field", type: "text" },
-    { name: "eq", label: "Equals", type: "text" }
+    { name: "eq", label: "Equals", type: "text" },
+    { name: "expr", label: "Expression (e.g. score > 10 && city != 'Paris')", type: "text" }
   ],
   reduce: [
     { name: "key", label: "Key", type: "text" },
-    { name: "agg", label: "Aggregation", type: "select", options: ["count", "sum", "avg"] }
+    { name: "agg", label: "Aggregation", type: "select", options: ["count", "sum", "min", "max", "avg", "count_distinct", "first", "last", "stddev", "percentile"] },
+    { name: "field", label: "Value field", type: "text" }
   ],
   // Add more as needed
 };
@@ -1,34 +1,124 @@
 package api
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "net/http"
     "io"
+    "time"
     "goxstream/internal/model"
     "goxstream/internal/engine"
+    "goxstream/internal/job"
 )
 
-func StartAPIServer(addr string) error {
-    http.HandleFunc("/jobs", jobHandler)
-    return http.ListenAndServe(addr, nil)
+type server struct {
+    jobs *job.Manager
 }
 
-func jobHandler(w http.ResponseWriter, r *http.Request) {
+// shutdownTimeout bounds how long StartAPIServer waits for running jobs to
+// drain after ctx is cancelled.
+const shutdownTimeout = 30 * time.Second
 
-	// CORS: allow all
+// StartAPIServer serves the REST API until ctx is cancelled, then stops
+// accepting requests and gracefully cancels all running jobs.
+func StartAPIServer(ctx context.Context, addr string) error {
+    s := &server{jobs: job.NewManager(engine.BuildAndRunPipeline)}
+    mux := http.NewServeMux()
+    mux.HandleFunc("/jobs", s.jobsHandler)
+    mux.HandleFunc("/jobs/{id}", s.jobHandler)
+    httpServer := &http.Server{Addr: addr, Handler: mux}
+
+    errCh := make(chan error, 1)
+    go func() {
+        errCh <- httpServer.ListenAndServe()
+    }()
+
+    select {
+    case err := <-errCh:
+        return err
+    case <-ctx.Done():
+    }
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := httpServer.Shutdown(shutdownCtx); err != nil {
+        return err
+    }
+    return s.jobs.Shutdown(shutdownCtx)
+}
+
+// allowCORS sets the CORS headers and reports whether the request was a
+// preflight that has already been answered.
+func allowCORS(w http.ResponseWriter, r *http.Request, methods string) bool {
+    // CORS: allow all
     w.Header().Set("Access-Control-Allow-Origin", "*")
     if r.Method == "OPTIONS" {
-        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Methods", methods)
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
         w.WriteHeader(http.StatusOK)
+        return true
+    }
+    return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+// jobsHandler serves POST /jobs (submit) and GET /jobs (list).
+func (s *server) jobsHandler(w http.ResponseWriter, r *http.Request) {
+    if allowCORS(w, r, "GET, POST, OPTIONS") {
         return
     }
 
-    if r.Method != "POST" {
+    switch r.Method {
+    case "GET":
+        statuses := []job.Status{}
+        for _, j := range s.jobs.List() {
+            statuses = append(statuses, j.Status())
+        }
+        writeJSON(w, http.StatusOK, statuses)
+    case "POST":
+        s.submitJob(w, r)
+    default:
         http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// jobHandler serves GET /jobs/{id} (status) and DELETE /jobs/{id} (cancel).
+func (s *server) jobHandler(w http.ResponseWriter, r *http.Request) {
+    if allowCORS(w, r, "GET, DELETE, OPTIONS") {
         return
     }
 
+    id := r.PathValue("id")
+    switch r.Method {
+    case "GET":
+        j, err := s.jobs.Get(id)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, j.Status())
+    case "DELETE":
+        j, err := s.jobs.Cancel(id)
+        switch {
+        case errors.Is(err, job.ErrNotFound):
+            http.Error(w, err.Error(), http.StatusNotFound)
+        case errors.Is(err, job.ErrAlreadyFinished):
+            http.Error(w, err.Error(), http.StatusConflict)
+        default:
+            writeJSON(w, http.StatusAccepted, j.Status())
+        }
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) {
     // Read the entire body ONCE
     bodyBytes, err := io.ReadAll(r.Body)
     if err != nil {
@@ -50,23 +140,26 @@ func jobHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if src, ok := pipelineMap["source"].(map[string]interface{}); ok {
-        spec.Source.Raw = src
-    } else {
-        http.Error(w, "missing source in pipeline", http.StatusBadRequest)
-        return
+    // DAG pipelines carry their sources and sinks as nodes
+    if !spec.IsGraph() {
+        if src, ok := pipelineMap["source"].(map[string]interface{}); ok {
+            spec.Source.Raw = src
+        } else {
+            http.Error(w, "missing source in pipeline", http.StatusBadRequest)
+            return
+        }
+        if sink, ok := pipelineMap["sink"].(map[string]interface{}); ok {
+            spec.Sink.Raw = sink
+        } else {
+            http.Error(w, "missing sink in pipeline", http.StatusBadRequest)
+            return
+        }
     }
-    if sink, ok := pipelineMap["sink"].(map[string]interface{}); ok {
-        spec.Sink.Raw = sink
-    } else {
-        http.Error(w, "missing sink in pipeline", http.StatusBadRequest)
+    if err := engine.Validate(spec); err != nil {
+        http.Error(w, "invalid pipeline: "+err.Error(), http.StatusBadRequest)
         return
     }
 
-    go func() {
-        engine.BuildAndRunPipeline(spec)
-    }()
-
-    w.WriteHeader(http.StatusAccepted)
-    w.Write([]byte(`{"status":"started"}`))
+    j := s.jobs.Submit(spec)
+    writeJSON(w, http.StatusAccepted, j.Status())
 }
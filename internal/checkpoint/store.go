@@ -0,0 +1,106 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// retain is how many completed checkpoints are kept on disk.
+const retain = 3
+
+// Checkpoint is a consistent snapshot of a pipeline: the source offsets of
+// the last record that went into it, the state of every stateful operator
+// and what each sink, by node ID, needs to resume its output.
+type Checkpoint struct {
+	ID        int64                      `json:"id"`
+	CreatedAt time.Time                  `json:"created_at"`
+	Offsets   model.Offsets              `json:"offsets"`
+	Operators map[string]json.RawMessage `json:"operators"`
+	Sinks     map[string]json.RawMessage `json:"sinks,omitempty"`
+}
+
+// Store keeps checkpoints as JSON files in a local directory.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("checkpoint dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("chk-%08d.json", id))
+}
+
+// Save writes cp atomically and prunes old checkpoints.
+func (s *Store) Save(cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint %d: %w", cp.ID, err)
+	}
+	tmp := s.path(cp.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write checkpoint %d: %w", cp.ID, err)
+	}
+	if err := os.Rename(tmp, s.path(cp.ID)); err != nil {
+		return fmt.Errorf("commit checkpoint %d: %w", cp.ID, err)
+	}
+	return s.prune()
+}
+
+// Latest returns the most recent checkpoint, or nil if there is none.
+func (s *Store) Latest() (*Checkpoint, error) {
+	names, err := s.list()
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, names[len(names)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// list returns the committed checkpoint file names, oldest first.
+func (s *Store) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("list checkpoints: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "chk-") && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) prune() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	for len(names) > retain {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			return fmt.Errorf("prune checkpoint: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package compression wraps file readers and writers in the codecs the file
+// source and sink support: gzip (.gz), zstd (.zst), lz4 (.lz4) and framed
+// snappy (.snappy).
+package compression
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// None is the codec of plain files.
+const None = "none"
+
+var extensions = map[string]string{
+	".gz":     "gzip",
+	".zst":    "zstd",
+	".lz4":    "lz4",
+	".snappy": "snappy",
+}
+
+// Detect returns the codec of the file at path: the given one if it is set,
+// and otherwise the one its extension implies, or None.
+func Detect(path, codec string) (string, error) {
+	switch codec {
+	case "":
+		if c, ok := extensions[filepath.Ext(path)]; ok {
+			return c, nil
+		}
+		return None, nil
+	case None, "gzip", "zstd", "lz4", "snappy":
+		return codec, nil
+	}
+	return "", fmt.Errorf("compression must be none, gzip, zstd, lz4 or snappy, got %q", codec)
+}
+
+// NewReader returns a reader decompressing r with codec. Concatenated
+// streams, such as a file appended to after Close, read as one.
+func NewReader(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "lz4":
+		br := bufio.NewReader(r)
+		return io.NopCloser(&lz4Frames{r: br, zr: lz4.NewReader(br)}), nil
+	case "snappy":
+		return io.NopCloser(snappy.NewReader(r)), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// Writer is a compressing writer. Flush writes out everything buffered so
+// far, so it can be decompressed without what follows; Close ends the
+// compressed stream but leaves the underlying writer open.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NewWriter returns a writer compressing into w with codec.
+func NewWriter(w io.Writer, codec string) (Writer, error) {
+	switch codec {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "lz4":
+		return lz4.NewWriter(w), nil
+	case "snappy":
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nopCloser{w}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Flush() error { return nil }
+func (nopCloser) Close() error { return nil }
+
+// lz4Frames reads a series of concatenated lz4 frames, such as a file that
+// was appended to, as one stream.
+type lz4Frames struct {
+	r  *bufio.Reader
+	zr *lz4.Reader
+}
+
+func (l *lz4Frames) Read(p []byte) (int, error) {
+	n, err := l.zr.Read(p)
+	if err == io.EOF {
+		if _, perr := l.r.Peek(1); perr == nil {
+			l.zr.Reset(l.r)
+			err = nil
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,396 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"goxstream/internal/checkpoint"
+	"goxstream/internal/model"
+	"goxstream/internal/sink"
+)
+
+const defaultCheckpointInterval = 10 * time.Second
+
+// coordinator drives barrier-aligned checkpoints. Every interval it asks
+// each source's injector to emit a barrier, collects an acknowledgement from
+// every stage the barrier passes through, and commits the checkpoint once all
+// of them have seen it.
+//
+// A checkpoint only completes if every source is still running, so once one
+// source of a multi-source graph finishes, the checkpoints whose barrier it
+// never emitted are dropped and only the final checkpoint is taken. A failed
+// snapshot or save is reported to fail, which stops the job.
+type coordinator struct {
+	store    *checkpoint.Store
+	interval time.Duration
+	stages   int // acknowledgements needed to complete a checkpoint
+	fail     func(error)
+
+	mu        sync.Mutex
+	nextID    int64
+	pending   map[int64]*checkpoint.Checkpoint
+	acks      map[int64]int
+	offsets   model.Offsets // offsets restored from the previous run
+	injectors []*injector
+	finished  bool                       // a source has ended
+	sinks     map[string]json.RawMessage // state the sinks ended with
+
+	saveMu sync.Mutex // serializes saves, which run on the acking goroutines
+	saved  int64      // ID of the latest saved checkpoint
+}
+
+// injector sits right after one source. It remembers the offset of every
+// record it hands downstream and emits a barrier when triggered. Its source
+// receives the offsets of every saved checkpoint on committed, which holds
+// only the latest.
+type injector struct {
+	trigger   chan int64
+	done      chan struct{}
+	committed chan model.Offsets
+	offsets   model.Offsets // guarded by coordinator.mu
+	last      int64         // ID of the last barrier emitted, guarded by coordinator.mu
+}
+
+func newCoordinator(spec *model.CheckpointSpec, stages int, fail func(error)) (*coordinator, error) {
+	if spec.Dir == "" {
+		return nil, fmt.Errorf("checkpoint expects 'dir'")
+	}
+	interval := defaultCheckpointInterval
+	if spec.Interval != "" {
+		d, err := time.ParseDuration(spec.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint interval: %w", err)
+		}
+		interval = d
+	}
+	store, err := checkpoint.NewStore(spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return &coordinator{
+		store:    store,
+		interval: interval,
+		stages:   stages,
+		fail:     fail,
+		nextID:   1,
+		pending:  make(map[int64]*checkpoint.Checkpoint),
+		acks:     make(map[int64]int),
+		offsets:  model.Offsets{},
+		sinks:    make(map[string]json.RawMessage),
+	}, nil
+}
+
+// latest loads the checkpoint to resume from, if any, and continues the ID
+// sequence after it.
+func (c *coordinator) latest() (*checkpoint.Checkpoint, error) {
+	cp, err := c.store.Latest()
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.nextID = cp.ID + 1
+	for k, v := range cp.Offsets {
+		c.offsets[k] = v
+	}
+	c.mu.Unlock()
+	return cp, nil
+}
+
+// newInjector registers a source with the coordinator. It must be called
+// before start.
+func (c *coordinator) newInjector() *injector {
+	inj := &injector{
+		trigger:   make(chan int64),
+		done:      make(chan struct{}),
+		committed: make(chan model.Offsets, 1),
+		offsets:   model.Offsets{},
+	}
+	c.injectors = append(c.injectors, inj)
+	return inj
+}
+
+// start triggers a checkpoint on every injector each interval until ctx is
+// cancelled.
+func (c *coordinator) start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			id, ok := c.begin()
+			if !ok {
+				return
+			}
+			for _, inj := range c.injectors {
+				select {
+				case inj.trigger <- id:
+				case <-inj.done:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+}
+
+// begin registers a new pending checkpoint and returns its ID. It returns
+// false once a source has ended, since no checkpoint could complete.
+func (c *coordinator) begin() (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return 0, false
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = &checkpoint.Checkpoint{
+		ID:        id,
+		Offsets:   c.copyOffsets(),
+		Operators: make(map[string]json.RawMessage),
+		Sinks:     make(map[string]json.RawMessage),
+	}
+	return id, true
+}
+
+// finish marks the injector's source as ended, and drops the pending
+// checkpoints whose barrier it did not emit.
+func (c *coordinator) finish(inj *injector) {
+	close(inj.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished = true
+	for id := range c.pending {
+		if id > inj.last {
+			delete(c.pending, id)
+			delete(c.acks, id)
+		}
+	}
+}
+
+// barrier records the injector's offsets into checkpoint id and returns the
+// barrier to send downstream.
+func (c *coordinator) barrier(inj *injector, id int64) model.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inj.last = id
+	if cp, ok := c.pending[id]; ok {
+		for k, v := range inj.offsets {
+			cp.Offsets[k] = v
+		}
+	}
+	return model.Event{Kind: model.KindBarrier, Checkpoint: id, Timestamp: time.Now()}
+}
+
+func (c *coordinator) advance(inj *injector, off *model.Offset) {
+	c.mu.Lock()
+	inj.offsets[off.Key] = off.Value
+	c.mu.Unlock()
+}
+
+// ack records that a stage has seen barrier id, together with the state it
+// snapshotted. A failed snapshot abandons the checkpoint and fails the job;
+// so does failing to save a complete one.
+func (c *coordinator) ack(id int64, state map[string]json.RawMessage, err error) {
+	c.mu.Lock()
+	cp, ok := c.pending[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	if err != nil {
+		delete(c.pending, id)
+		delete(c.acks, id)
+		c.mu.Unlock()
+		c.fail(fmt.Errorf("checkpoint %d: %w", id, err))
+		return
+	}
+	for k, v := range state {
+		cp.Operators[k] = v
+	}
+	c.acks[id]++
+	if c.acks[id] < c.stages {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, id)
+	delete(c.acks, id)
+	c.mu.Unlock()
+
+	if err := c.save(cp); err != nil {
+		c.fail(err)
+	}
+}
+
+// ackSink records that the sink of node has written everything before
+// barrier id, together with the state it resumes from.
+func (c *coordinator) ackSink(id int64, node string, state json.RawMessage) {
+	c.mu.Lock()
+	if cp, ok := c.pending[id]; ok && state != nil {
+		cp.Sinks[node] = state
+	}
+	c.mu.Unlock()
+	c.ack(id, nil, nil)
+}
+
+// endSink records the state the sink of node ended with, for the final
+// checkpoint.
+func (c *coordinator) endSink(node string, state json.RawMessage) {
+	c.mu.Lock()
+	c.sinks[node] = state
+	c.mu.Unlock()
+}
+
+// save writes a complete checkpoint unless a later one is already saved.
+func (c *coordinator) save(cp *checkpoint.Checkpoint) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	if cp.ID <= c.saved {
+		return nil
+	}
+	cp.CreatedAt = time.Now()
+	if err := c.store.Save(cp); err != nil {
+		return err
+	}
+	c.saved = cp.ID
+	for _, inj := range c.injectors {
+		// Replace offsets the source has not taken yet; saves are
+		// serialized, so the send cannot block.
+		select {
+		case <-inj.committed:
+		default:
+		}
+		inj.committed <- cp.Offsets
+	}
+	return nil
+}
+
+// commitFinal writes a checkpoint once the pipeline has stopped, so a restart
+// continues after everything that was already processed and flushed.
+func (c *coordinator) commitFinal(state map[string]json.RawMessage) error {
+	c.mu.Lock()
+	cp := &checkpoint.Checkpoint{
+		ID:        c.nextID,
+		Offsets:   c.copyOffsets(),
+		Operators: state,
+		Sinks:     c.sinks,
+	}
+	c.nextID++
+	c.mu.Unlock()
+	return c.save(cp)
+}
+
+// copyOffsets returns the restored offsets overlaid with everything the
+// injectors have handed on so far. It must be called with c.mu held.
+func (c *coordinator) copyOffsets() model.Offsets {
+	offsets := make(model.Offsets, len(c.offsets))
+	for k, v := range c.offsets {
+		offsets[k] = v
+	}
+	for _, inj := range c.injectors {
+		for k, v := range inj.offsets {
+			offsets[k] = v
+		}
+	}
+	return offsets
+}
+
+// forwardSource hands events from a source to the graph and counts them.
+// With checkpointing enabled (inj != nil) it also tracks record offsets and
+// emits barriers when triggered; with a watermark generator (wm != nil) it
+// follows each record that advances the watermark with a watermark marker.
+// It closes out when in is exhausted or ctx is cancelled.
+func forwardSource(ctx context.Context, in <-chan model.Event, out chan<- model.Event, stats *Stats, c *coordinator, inj *injector, wm *watermarkGenerator) {
+	defer close(out)
+	var trigger <-chan int64
+	if inj != nil {
+		defer c.finish(inj)
+		trigger = inj.trigger
+	}
+	var idle <-chan time.Time
+	if wm != nil && wm.idle > 0 {
+		ticker := time.NewTicker(wm.idle / 2)
+		defer ticker.Stop()
+		idle = ticker.C
+	}
+	send := func(e model.Event) bool {
+		select {
+		case out <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		var e model.Event
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-trigger:
+			e = c.barrier(inj, id)
+		case now := <-idle:
+			mark, ok := wm.onIdle(now)
+			if ok && !send(mark) {
+				return
+			}
+			continue
+		case rec, ok := <-in:
+			if !ok {
+				return
+			}
+			e = rec
+		}
+		if !send(e) {
+			return
+		}
+		if e.IsRecord() {
+			stats.RecordsIn.Add(1)
+			if inj != nil && e.Offset != nil {
+				c.advance(inj, e.Offset)
+			}
+			if wm != nil {
+				if mark, ok := wm.onRecord(e, time.Now()); ok && !send(mark) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// sinkInput passes records, checkpoint barriers and watermarks on to the
+// sink, and counts the records that reach it. The sink acknowledges a
+// barrier itself, through sinkAck, once it has written everything before
+// it.
+func sinkInput(in <-chan model.Event, stats *Stats) <-chan model.Event {
+	out := make(chan model.Event)
+	go func() {
+		defer close(out)
+		for e := range in {
+			out <- e
+			if e.IsRecord() {
+				stats.RecordsOut.Add(1)
+			}
+		}
+	}()
+	return out
+}
+
+// sinkAck returns the function the sink of node id acknowledges barriers
+// with.
+func sinkAck(coord *coordinator, id string) sink.Ack {
+	return func(checkpoint int64, state json.RawMessage) {
+		switch {
+		case coord == nil:
+		case checkpoint == sink.FinalCheckpoint:
+			coord.endSink(id, state)
+		default:
+			coord.ackSink(checkpoint, id, state)
+		}
+	}
+}
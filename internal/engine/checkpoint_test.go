@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// testCoordinator returns a coordinator saving to dir that collects the
+// errors it reports.
+func testCoordinator(t *testing.T, dir string) (*coordinator, *[]error) {
+	t.Helper()
+	var errs []error
+	c, err := newCoordinator(&model.CheckpointSpec{Dir: dir}, 1, func(err error) { errs = append(errs, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &errs
+}
+
+// A checkpoint whose barrier a finished source never emitted can no longer
+// complete, and must not stay pending.
+func TestCoordinatorExpiresCheckpointsWhenASourceEnds(t *testing.T) {
+	c, _ := testCoordinator(t, t.TempDir())
+	a, b := c.newInjector(), c.newInjector()
+	first, _ := c.begin()
+	c.barrier(a, first)
+	c.barrier(b, first)
+	second, _ := c.begin()
+	c.barrier(a, second)
+	c.finish(b)
+
+	if _, ok := c.pending[first]; !ok {
+		t.Errorf("checkpoint %d, whose barrier every source emitted, was dropped", first)
+	}
+	if _, ok := c.pending[second]; ok {
+		t.Errorf("checkpoint %d is still pending", second)
+	}
+	if _, ok := c.begin(); ok {
+		t.Error("began a checkpoint after a source ended")
+	}
+}
+
+// A checkpoint completing after a later one must not replace it.
+func TestCoordinatorKeepsTheLatestCheckpoint(t *testing.T) {
+	c, errs := testCoordinator(t, t.TempDir())
+	inj := c.newInjector()
+	first, _ := c.begin()
+	c.barrier(inj, first)
+	c.advance(inj, &model.Offset{Key: "orders/0", Value: 5})
+	second, _ := c.begin()
+	c.barrier(inj, second)
+	c.ack(second, nil, nil)
+	c.ack(first, nil, nil)
+	if len(*errs) > 0 {
+		t.Fatal(*errs)
+	}
+	cp, err := c.store.Latest()
+	if err != nil || cp == nil || cp.ID != second {
+		t.Errorf("latest checkpoint = %v, %v, want %d", cp, err, second)
+	}
+	// The source learns the offsets of the saved checkpoint only.
+	select {
+	case offsets := <-inj.committed:
+		if offsets["orders/0"] != 5 {
+			t.Errorf("committed offsets = %v, want orders/0 at 5", offsets)
+		}
+	default:
+		t.Error("the source was not told about the saved checkpoint")
+	}
+}
+
+func TestCoordinatorReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+	c, errs := testCoordinator(t, dir)
+	inj := c.newInjector()
+	first, _ := c.begin()
+	c.barrier(inj, first)
+	c.ack(first, nil, errors.New("snapshot failed"))
+
+	// The store cannot write the next checkpoint's file.
+	second, _ := c.begin()
+	c.barrier(inj, second)
+	if err := os.Mkdir(filepath.Join(dir, "chk-00000002.json.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c.ack(second, nil, nil)
+
+	if len(*errs) != 2 || (*errs)[0].Error() != "checkpoint 1: snapshot failed" ||
+		!strings.HasPrefix((*errs)[1].Error(), "write checkpoint 2:") {
+		t.Errorf("errors = %v, want the snapshot and the save failure", *errs)
+	}
+}
+
+// A checkpoint that cannot be saved fails a running job.
+func TestCheckpointFailureFailsTheJob(t *testing.T) {
+	dir := t.TempDir()
+	in := writeInput(t, dir, "in.csv", lateInput...)
+	cpDir := filepath.Join(dir, "checkpoints")
+	if err := os.MkdirAll(filepath.Join(cpDir, "chk-00000001.json.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src := fileSource(in)
+	src["follow"] = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := BuildAndRunPipeline(ctx, model.PipelineSpec{
+		Source:     model.SourceSpec{Raw: src},
+		Sink:       model.SinkSpec{Raw: jsonlSink(filepath.Join(dir, "out.jsonl"))},
+		Checkpoint: &model.CheckpointSpec{Dir: cpDir, Interval: "10ms"},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "write checkpoint 1:") || ctx.Err() != nil {
+		t.Errorf("pipeline error = %v, want the failed checkpoint", err)
+	}
+}
+
+// A resubmitted job continues after the records the previous run processed,
+// with the operator state it checkpointed.
+func TestCheckpointRestore(t *testing.T) {
+	dir := t.TempDir()
+	in := writeInput(t, dir, "in.csv", "ts,v", "2025-07-05T21:00:01Z,a", "2025-07-05T21:00:02Z,b")
+	spec := func(out string) model.PipelineSpec {
+		return model.PipelineSpec{
+			Source:     model.SourceSpec{Raw: fileSource(in)},
+			Operators:  []model.OperatorSpec{{Type: "dedup", Params: map[string]interface{}{"key": "v", "max_keys": 100.0}}},
+			Sink:       model.SinkSpec{Raw: jsonlSink(filepath.Join(dir, out))},
+			Checkpoint: &model.CheckpointSpec{Dir: filepath.Join(dir, "checkpoints")},
+		}
+	}
+	run(t, spec("first.jsonl"))
+	f, err := os.OpenFile(in, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("2025-07-05T21:00:03Z,c\n2025-07-05T21:00:04Z,a\n")
+	f.Close()
+	run(t, spec("second.jsonl"))
+
+	for out, want := range map[string][]string{"first.jsonl": {"a", "b"}, "second.jsonl": {"c"}} {
+		var got []string
+		for _, r := range readRecords(t, filepath.Join(dir, out)) {
+			got = append(got, r["v"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s = %v, want %v", out, got, want)
+		}
+	}
+	// Each run ended with a checkpoint.
+	data, err := os.ReadFile(filepath.Join(dir, "checkpoints", "chk-00000002.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct{ Offsets model.Offsets }
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Offsets) != 1 {
+		t.Errorf("offsets = %v, want the input file's", saved.Offsets)
+	}
+}
+
+// A resubmitted job appends to the file its sink wrote, rather than
+// starting it over.
+func TestCheckpointRestoreKeepsTheSinkFile(t *testing.T) {
+	dir := t.TempDir()
+	in := writeInput(t, dir, "in.csv", "v,n", "a,1", "b,2")
+	out := filepath.Join(dir, "out.csv")
+	spec := model.PipelineSpec{
+		Source:     model.SourceSpec{Raw: map[string]interface{}{"type": "file", "path": in}},
+		Sink:       model.SinkSpec{Raw: map[string]interface{}{"type": "file", "path": out}},
+		Checkpoint: &model.CheckpointSpec{Dir: filepath.Join(dir, "checkpoints")},
+	}
+	run(t, spec)
+	f, err := os.OpenFile(in, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("c,3\n")
+	f.Close()
+	run(t, spec)
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "n,v\n1,a\n2,b\n3,c\n"; string(data) != want {
+		t.Errorf("output = %q, want %q", data, want)
+	}
+}
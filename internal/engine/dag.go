@@ -0,0 +1,302 @@
+package engine
+
+import (
+	"fmt"
+
+	"goxstream/internal/model"
+	"goxstream/internal/operator"
+)
+
+const (
+	nodeSource = "source"
+	nodeSink   = "sink"
+	nodeUnion  = "union"
+	nodeJoin   = "join"
+)
+
+// plan is a validated pipeline graph. Runs of operator nodes connected one
+// to one are fused into chains, so a linear pipeline becomes a single chain
+// between its source and sink, exactly as before DAG support.
+type plan struct {
+	sources []*vertex
+	chains  []*vertex
+	sinks   []*vertex
+}
+
+// vertex is a unit that runs on its own goroutine: a source, a fused chain
+// of operators, or a sink.
+type vertex struct {
+	id     string
+	params map[string]interface{} // source or sink config
+	ops    []model.NodeSpec       // operator nodes of a chain, in order
+	in     []*link
+	out    []*link
+}
+
+// link is a buffered edge between two vertices.
+type link struct {
+	from, to     *vertex
+	fromID, toID string // the node IDs at either end
+	buffer       model.BufferSpec
+	output       string // side output of the from node; empty for its main output
+	input        string // tags records for a join node with the side they belong to
+}
+
+// linearGraph expresses a Source/Operators/Sink spec as a graph. Operator
+// node IDs match the checkpoint keys of a linear pipeline.
+func linearGraph(spec model.PipelineSpec) ([]model.NodeSpec, []model.EdgeSpec) {
+	nodes := []model.NodeSpec{{ID: nodeSource, Type: nodeSource, Params: spec.Source.Raw}}
+	for i, op := range spec.Operators {
+		nodes = append(nodes, model.NodeSpec{ID: fmt.Sprintf("%d-%s", i, op.Type), Type: op.Type, Params: op.Params})
+	}
+	nodes = append(nodes, model.NodeSpec{ID: nodeSink, Type: nodeSink, Params: spec.Sink.Raw})
+	var edges []model.EdgeSpec
+	for i := 1; i < len(nodes); i++ {
+		edges = append(edges, model.EdgeSpec{From: nodes[i-1].ID, To: nodes[i].ID})
+	}
+	return nodes, edges
+}
+
+// Validate checks that spec describes a runnable pipeline graph without
+// building any operator, source or sink.
+func Validate(spec model.PipelineSpec) error {
+	_, err := newPlan(spec)
+	return err
+}
+
+func newPlan(spec model.PipelineSpec) (*plan, error) {
+	nodes, edges := spec.Nodes, spec.Edges
+	if !spec.IsGraph() {
+		nodes, edges = linearGraph(spec)
+	}
+
+	byID := make(map[string]model.NodeSpec, len(nodes))
+	for _, n := range nodes {
+		if n.ID == "" {
+			return nil, fmt.Errorf("node missing 'id'")
+		}
+		if _, dup := byID[n.ID]; dup {
+			return nil, fmt.Errorf("duplicate node id: %s", n.ID)
+		}
+		if n.Type == "" {
+			return nil, fmt.Errorf("node %s missing 'type'", n.ID)
+		}
+		if (n.Type == nodeSource || n.Type == nodeSink) && n.Params == nil {
+			return nil, fmt.Errorf("%s node %s missing params", n.Type, n.ID)
+		}
+		byID[n.ID] = n
+	}
+	ins := make(map[string][]string)
+	outs := make(map[string][]string)
+	split := make(map[[2]string]bool)     // edges that must not be fused
+	sideEdges := make(map[[2]string]bool) // side outputs with an edge, by node
+	for _, e := range edges {
+		if _, ok := byID[e.From]; !ok {
+			return nil, fmt.Errorf("edge from unknown node: %s", e.From)
+		}
+		if _, ok := byID[e.To]; !ok {
+			return nil, fmt.Errorf("edge to unknown node: %s", e.To)
+		}
+		outs[e.From] = append(outs[e.From], e.To)
+		ins[e.To] = append(ins[e.To], e.From)
+		if e.Output != "" {
+			if t := byID[e.From].Type; !hasSideOutput(t, e.Output) {
+				return nil, fmt.Errorf("edge %s -> %s: %s node %s has no %q output", e.From, e.To, t, e.From, e.Output)
+			}
+			sideEdges[[2]string{e.From, e.Output}] = true
+		}
+		if e.Buffer != nil || e.Output != "" {
+			split[[2]string{e.From, e.To}] = true
+		}
+	}
+
+	for id, n := range byID {
+		n, err := resolveLateOutput(n, sideEdges[[2]string{id, operator.LateOutput}])
+		if err != nil {
+			return nil, err
+		}
+		byID[id] = n
+	}
+
+	var nSources, nSinks int
+	for _, n := range nodes {
+		switch n.Type {
+		case nodeSource:
+			nSources++
+			if len(ins[n.ID]) > 0 {
+				return nil, fmt.Errorf("source %s cannot have inputs", n.ID)
+			}
+			if len(outs[n.ID]) == 0 {
+				return nil, fmt.Errorf("source %s is not connected", n.ID)
+			}
+		case nodeSink:
+			nSinks++
+			if len(outs[n.ID]) > 0 {
+				return nil, fmt.Errorf("sink %s cannot have outputs", n.ID)
+			}
+			if len(ins[n.ID]) == 0 {
+				return nil, fmt.Errorf("sink %s is not connected", n.ID)
+			}
+		default:
+			if len(ins[n.ID]) == 0 || len(outs[n.ID]) == 0 {
+				return nil, fmt.Errorf("node %s must have inputs and outputs", n.ID)
+			}
+		}
+		if n.Type == nodeJoin {
+			if err := validateJoin(n, ins[n.ID]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if nSources == 0 || nSinks == 0 {
+		return nil, fmt.Errorf("pipeline needs at least one source and one sink")
+	}
+
+	order, err := topoSort(nodes, ins, outs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fuse operator nodes into chains: a node joins its predecessor's chain
+	// when it is that predecessor's only output and has no other input, and
+	// the edge between them has no buffer of its own and is not a side
+	// output.
+	p := &plan{}
+	vertexOf := make(map[string]*vertex)
+	for _, id := range order {
+		n := byID[id]
+		switch n.Type {
+		case nodeSource:
+			v := &vertex{id: id, params: n.Params}
+			p.sources = append(p.sources, v)
+			vertexOf[id] = v
+		case nodeSink:
+			v := &vertex{id: id, params: n.Params}
+			p.sinks = append(p.sinks, v)
+			vertexOf[id] = v
+		default:
+			if len(ins[id]) == 1 {
+				prev := byID[ins[id][0]]
+				if prev.Type != nodeSource && len(outs[prev.ID]) == 1 && !split[[2]string{prev.ID, id}] {
+					v := vertexOf[prev.ID]
+					if n.Type != nodeUnion {
+						v.ops = append(v.ops, n)
+					}
+					vertexOf[id] = v
+					continue
+				}
+			}
+			v := &vertex{id: id}
+			if n.Type != nodeUnion {
+				v.ops = append(v.ops, n)
+			}
+			p.chains = append(p.chains, v)
+			vertexOf[id] = v
+		}
+	}
+	type linkKey struct {
+		from, to *vertex
+		output   string
+	}
+	linked := make(map[linkKey]bool)
+	for _, e := range edges {
+		from, to := vertexOf[e.From], vertexOf[e.To]
+		if from == to || linked[linkKey{from, to, e.Output}] {
+			continue // fused into the same chain, or a duplicate edge
+		}
+		linked[linkKey{from, to, e.Output}] = true
+		b, err := resolveBuffer(e.Buffer, spec.Buffer)
+		if err != nil {
+			return nil, fmt.Errorf("edge %s -> %s: %w", e.From, e.To, err)
+		}
+		l := &link{from: from, to: to, fromID: e.From, toID: e.To, buffer: b, output: e.Output}
+		if byID[e.To].Type == nodeJoin {
+			l.input = e.From
+		}
+		from.out = append(from.out, l)
+		to.in = append(to.in, l)
+	}
+	return p, nil
+}
+
+// hasSideOutput reports whether nodes of type typ emit records on output.
+func hasSideOutput(typ, output string) bool {
+	if typ == nodeSource || typ == nodeSink || typ == nodeUnion {
+		return false
+	}
+	for _, o := range operator.SideOutputs(typ) {
+		if o == output {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLateOutput sends a node's late events to its late output when an
+// edge consumes it, and keeps them tagged on the main output otherwise, so
+// that none are lost. A late_output the edges contradict is an error.
+func resolveLateOutput(n model.NodeSpec, lateEdge bool) (model.NodeSpec, error) {
+	if !hasSideOutput(n.Type, operator.LateOutput) {
+		return n, nil
+	}
+	switch mode, _ := n.Params["late_output"].(string); {
+	case mode == "" && lateEdge:
+		params := make(map[string]interface{}, len(n.Params)+1)
+		for k, v := range n.Params {
+			params[k] = v
+		}
+		params["late_output"] = "side"
+		n.Params = params
+	case mode == "side" && !lateEdge:
+		return n, fmt.Errorf("node %s sends late events to its %q output, which has no edge", n.ID, operator.LateOutput)
+	case mode == "tag" && lateEdge:
+		return n, fmt.Errorf("node %s tags late events, so its %q output would stay empty", n.ID, operator.LateOutput)
+	}
+	return n, nil
+}
+
+// validateJoin checks that a join node's inputs are exactly its left and
+// right nodes.
+func validateJoin(n model.NodeSpec, ins []string) error {
+	left, _ := n.Params["left"].(string)
+	right, _ := n.Params["right"].(string)
+	seen := make(map[string]bool)
+	for _, in := range ins {
+		if in != left && in != right {
+			return fmt.Errorf("join %s: input %s is neither its left nor its right node", n.ID, in)
+		}
+		seen[in] = true
+	}
+	if !seen[left] || !seen[right] {
+		return fmt.Errorf("join %s needs edges from its left node %q and right node %q", n.ID, left, right)
+	}
+	return nil
+}
+
+// topoSort orders nodes so every edge points forward, failing on cycles.
+func topoSort(nodes []model.NodeSpec, ins, outs map[string][]string) ([]string, error) {
+	indegree := make(map[string]int, len(nodes))
+	var ready []string
+	for _, n := range nodes {
+		indegree[n.ID] = len(ins[n.ID])
+		if indegree[n.ID] == 0 {
+			ready = append(ready, n.ID)
+		}
+	}
+	var order []string
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		for _, next := range outs[id] {
+			indegree[next]--
+			if indegree[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if len(order) != len(nodes) {
+		return nil, fmt.Errorf("pipeline graph has a cycle")
+	}
+	return order, nil
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goxstream/internal/model"
+)
+
+// writeInput writes lines to a new file in dir and returns its path.
+func writeInput(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readRecords reads the records of a JSON Lines file.
+func readRecords(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]interface{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// fileSource is the params of a source reading a CSV file whose ts column
+// holds each record's event time.
+func fileSource(path string) map[string]interface{} {
+	return map[string]interface{}{"type": "file", "path": path, "timestamp": map[string]interface{}{"field": "ts"}}
+}
+
+func jsonlSink(path string) map[string]interface{} {
+	return map[string]interface{}{"type": "file", "path": path, "format": "jsonl"}
+}
+
+// run runs spec to completion, failing the test on error.
+func run(t *testing.T, spec model.PipelineSpec) RunStats {
+	t.Helper()
+	rs, err := BuildAndRunPipeline(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatalf("pipeline failed: %v", err)
+	}
+	return rs
+}
+
+// lateValues returns the v field of the records marked late=true.
+func lateValues(records []map[string]interface{}) []string {
+	var vs []string
+	for _, r := range records {
+		if r["late"] == true {
+			vs = append(vs, r["v"].(string))
+		}
+	}
+	return vs
+}
+
+// lateInput has one event, c, too late for its window: b's event time has
+// moved the watermark past the end of c's window.
+var lateInput = []string{
+	"ts,v",
+	"2025-07-05T21:00:01Z,a",
+	"2025-07-05T21:00:12Z,b",
+	"2025-07-05T21:00:03Z,c",
+}
+
+func watermarkWindow(params map[string]interface{}) model.NodeSpec {
+	p := map[string]interface{}{
+		"duration": "10s", "allowed_lateness": "0s",
+		"inner": map[string]interface{}{"type": "reduce", "params": map[string]interface{}{"agg": "count"}},
+	}
+	for k, v := range params {
+		p[k] = v
+	}
+	return model.NodeSpec{ID: "w", Type: "time_window_watermark", Params: p}
+}
+
+func TestPlanSideOutputs(t *testing.T) {
+	src := model.NodeSpec{ID: "in", Type: nodeSource, Params: map[string]interface{}{"type": "file"}}
+	out := model.NodeSpec{ID: "out", Type: nodeSink, Params: map[string]interface{}{"type": "file"}}
+	late := model.NodeSpec{ID: "late", Type: nodeSink, Params: map[string]interface{}{"type": "file"}}
+	filter := model.NodeSpec{ID: "f", Type: "filter", Params: map[string]interface{}{"expr": "true"}}
+	tests := []struct {
+		name  string
+		nodes []model.NodeSpec
+		edges []model.EdgeSpec
+		want  string // error; empty if the plan is valid
+	}{
+		{
+			name:  "late edge",
+			nodes: []model.NodeSpec{src, watermarkWindow(nil), out, late},
+			edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}, {From: "w", To: "late", Output: "late"}},
+		},
+		{
+			name:  "no late edge",
+			nodes: []model.NodeSpec{src, watermarkWindow(nil), out},
+			edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}},
+		},
+		{
+			name:  "side without late edge",
+			nodes: []model.NodeSpec{src, watermarkWindow(map[string]interface{}{"late_output": "side"}), out},
+			edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}},
+			want:  `node w sends late events to its "late" output, which has no edge`,
+		},
+		{
+			name:  "tag with late edge",
+			nodes: []model.NodeSpec{src, watermarkWindow(map[string]interface{}{"late_output": "tag"}), out, late},
+			edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}, {From: "w", To: "late", Output: "late"}},
+			want:  `node w tags late events, so its "late" output would stay empty`,
+		},
+		{
+			name:  "unknown output",
+			nodes: []model.NodeSpec{src, watermarkWindow(nil), out, late},
+			edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}, {From: "w", To: "late", Output: "early"}},
+			want:  `edge w -> late: time_window_watermark node w has no "early" output`,
+		},
+		{
+			name:  "operator without side outputs",
+			nodes: []model.NodeSpec{src, filter, out, late},
+			edges: []model.EdgeSpec{{From: "in", To: "f"}, {From: "f", To: "out"}, {From: "f", To: "late", Output: "late"}},
+			want:  `edge f -> late: filter node f has no "late" output`,
+		},
+		{
+			name:  "source side output",
+			nodes: []model.NodeSpec{src, out},
+			edges: []model.EdgeSpec{{From: "in", To: "out", Output: "late"}},
+			want:  `edge in -> out: source node in has no "late" output`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(model.PipelineSpec{Nodes: tt.nodes, Edges: tt.edges})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.want != "" && (err == nil || err.Error() != tt.want):
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Late events go, untagged, to the sink on the late edge and only there.
+func TestLateEdge(t *testing.T) {
+	count := map[string]interface{}{"type": "reduce", "params": map[string]interface{}{"agg": "count"}}
+	for _, w := range []model.NodeSpec{
+		watermarkWindow(nil),
+		{ID: "w", Type: "session_window", Params: map[string]interface{}{"gap": "5s", "inner": count}},
+	} {
+		t.Run(w.Type, func(t *testing.T) {
+			dir := t.TempDir()
+			in := writeInput(t, dir, "in.csv", lateInput...)
+			outPath, latePath := filepath.Join(dir, "out.jsonl"), filepath.Join(dir, "late.jsonl")
+			run(t, model.PipelineSpec{
+				Nodes: []model.NodeSpec{
+					{ID: "in", Type: nodeSource, Params: fileSource(in)},
+					w,
+					{ID: "out", Type: nodeSink, Params: jsonlSink(outPath)},
+					{ID: "late", Type: nodeSink, Params: jsonlSink(latePath)},
+				},
+				Edges: []model.EdgeSpec{{From: "in", To: "w"}, {From: "w", To: "out"}, {From: "w", To: "late", Output: "late"}},
+			})
+			late := readRecords(t, latePath)
+			if len(late) != 1 || late[0]["v"] != "c" || late[0]["late"] != nil {
+				t.Errorf("late output = %v, want the untagged record c", late)
+			}
+			if got := lateValues(readRecords(t, outPath)); got != nil {
+				t.Errorf("main output has late records %v", got)
+			}
+		})
+	}
+}
+
+// An out-of-order event too late for its window must reach the output of a
+// linear pipeline, tagged late, rather than a side output nothing consumes.
+func TestLinearPipelineLateEvents(t *testing.T) {
+	count := map[string]interface{}{"type": "reduce", "params": map[string]interface{}{"agg": "count"}}
+	w := watermarkWindow(nil)
+	tests := []struct {
+		name      string
+		op        model.OperatorSpec
+		watermark *model.WatermarkSpec
+	}{
+		{name: "time_window_watermark", op: model.OperatorSpec{Type: w.Type, Params: w.Params}},
+		{
+			name:      "time_window",
+			op:        model.OperatorSpec{Type: "time_window", Params: map[string]interface{}{"duration": "10s", "inner": count}},
+			watermark: &model.WatermarkSpec{MaxOutOfOrderness: "0s"},
+		},
+		{
+			name: "time_sliding_window",
+			op:   model.OperatorSpec{Type: "time_sliding_window", Params: map[string]interface{}{"size": "10s", "slide": "5s", "inner": count}},
+		},
+		{
+			name: "session_window",
+			op:   model.OperatorSpec{Type: "session_window", Params: map[string]interface{}{"gap": "5s", "inner": count}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := writeInput(t, dir, "in.csv", lateInput...)
+			outPath := filepath.Join(dir, "out.jsonl")
+			run(t, model.PipelineSpec{
+				Source:    model.SourceSpec{Raw: fileSource(in)},
+				Operators: []model.OperatorSpec{tt.op},
+				Sink:      model.SinkSpec{Raw: jsonlSink(outPath)},
+				Watermark: tt.watermark,
+			})
+			if got := lateValues(readRecords(t, outPath)); len(got) != 1 || got[0] != "c" {
+				t.Errorf("late records = %v, want [c] tagged on the main output", got)
+			}
+		})
+	}
+}
@@ -1,63 +1,285 @@
 package engine
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
     "fmt"
     "goxstream/internal/model"
-    "goxstream/internal/operator"
     "goxstream/internal/source"
     "goxstream/internal/sink"
+    "sync"
+    "time"
 )
 
-func BuildAndRunPipeline(spec model.PipelineSpec) error {
-    input := make(chan model.Event)
-    output := make(chan model.Event)
+// BuildAndRunPipeline builds the pipeline described by spec and runs it to
+// completion: it returns only once every source, operator chain and sink has
+// finished. Live counters are recorded into stats, which may be nil. The
+// returned error joins every stage failure, and ctx.Err() if ctx was
+// cancelled before the pipeline finished; the stages still drain and the
+// final checkpoint is still taken.
+func BuildAndRunPipeline(ctx context.Context, spec model.PipelineSpec, stats *Stats) (RunStats, error) {
+    if stats == nil {
+        stats = &Stats{}
+    }
+    run := RunStats{StartedAt: time.Now()}
+    finish := func() RunStats {
+        run.FinishedAt = time.Now()
+        run.Duration = run.FinishedAt.Sub(run.StartedAt)
+        run.RecordsIn = stats.RecordsIn.Load()
+        run.RecordsOut = stats.RecordsOut.Load()
+        run.Edges = stats.Edges()
+        run.Operators = stats.Operators()
+        run.Sinks = stats.Sinks()
+        return run
+    }
+
+    // We assume spec.Source.Raw is a map[string]interface{} with the full source config.
+    // If not, adjust according to how you parse your job/pipeline spec.
+    if !spec.IsGraph() && spec.Source.Raw == nil {
+        return finish(), fmt.Errorf("source config missing: spec.Source.Raw is nil")
+    }
+
+    p, err := newPlan(spec)
+    if err != nil {
+        return finish(), err
+    }
+    if spec.Watermark != nil {
+        if _, err := newWatermarkGenerator(spec.Watermark); err != nil {
+            return finish(), err
+        }
+    }
 
-    // Build operator chain
-    var ops []operator.Operator
-    for _, opSpec := range spec.Operators {
-        op, err := operator.BuildOperator(opSpec)
+    // Build operator chains
+    stages := make(map[*vertex]stage, len(p.chains))
+    acks := len(p.sinks)
+    for _, v := range p.chains {
+        st, err := newStage(v.ops, spec.Parallelism)
         if err != nil {
-            return fmt.Errorf("operator build error: %w", err)
+            return finish(), err
         }
-        ops = append(ops, op)
+        stages[v] = st
+        st.report(stats)
+        acks += st.acks()
     }
 
-    pipeline := Pipeline{Operators: ops}
+    // runCtx aborts the sources when any stage fails; ctx itself still
+    // drives graceful cancellation. Operator chains and sinks are never
+    // cancelled directly: they drain whatever the sources already emitted.
+    runCtx, abort := context.WithCancel(ctx)
+    defer abort()
+    drainCtx := context.WithoutCancel(runCtx)
 
-    // Run pipeline in background
-    go func() {
-        pipeline.Run(input, output)
-        // FLUSH LOGIC: Check if the last operator is a TimeWindowOperator, call Flush
-        if len(pipeline.Operators) > 0 {
-            if tsw, ok := pipeline.Operators[len(pipeline.Operators)-1].(*operator.TimeSlidingWindowOperator); ok {
-                for _, evt := range tsw.Flush() {
-                    output <- evt
+    var mu sync.Mutex
+    var errs []error
+    fail := func(err error) {
+        mu.Lock()
+        errs = append(errs, err)
+        mu.Unlock()
+        abort()
+    }
+
+    // Checkpointing: resume from the latest checkpoint, if there is one
+    var resume model.Offsets
+    var sinkState map[string]json.RawMessage
+    var coord *coordinator
+    if spec.Checkpoint != nil {
+        // Barriers are acknowledged by every operator chain and every sink.
+        coord, err = newCoordinator(spec.Checkpoint, acks, fail)
+        if err != nil {
+            return finish(), err
+        }
+        cp, err := coord.latest()
+        if err != nil {
+            return finish(), err
+        }
+        for _, st := range stages {
+            if cp != nil {
+                if err := st.restore(cp.Operators); err != nil {
+                    return finish(), fmt.Errorf("checkpoint %d: %w", cp.ID, err)
                 }
             }
-            if tw, ok := pipeline.Operators[len(pipeline.Operators)-1].(*operator.TimeWindowOperator); ok {
-                for _, evt := range tw.Flush() {
-                    output <- evt
-                }
+            st.attach(coord)
+        }
+        if cp != nil {
+            resume, sinkState = cp.Offsets, cp.Sinks
+        }
+    }
+
+    // One bounded queue per edge between vertices.
+    queues := make(map[*link]*queue)
+    for _, v := range append(append([]*vertex{}, p.sources...), p.chains...) {
+        for _, l := range v.out {
+            q := newQueue(l.buffer, stats.addEdge(l.fromID, l.toID, l.buffer.Size, l.buffer.Policy))
+            q.output, q.input = l.output, l.input
+            queues[l] = q
+        }
+    }
+    outputsOf := func(v *vertex) []*queue {
+        var qs []*queue
+        for _, l := range v.out {
+            qs = append(qs, queues[l])
+        }
+        return qs
+    }
+    inputOf := func(v *vertex) <-chan model.Event {
+        var chs []chan model.Event
+        for _, l := range v.in {
+            chs = append(chs, queues[l].out)
+        }
+        return mergeInputs(chs)
+    }
+
+    var wg sync.WaitGroup
+    // --------- Sinks ----------
+    for _, v := range p.sinks {
+        in := inputOf(v)
+        counters := &sink.Counters{}
+        stats.addSink(v.id, counters)
+        wg.Add(1)
+        go func(v *vertex) {
+            defer wg.Done()
+            sinkIn := sinkInput(in, stats)
+            if err := sink.BuildSink(ctx, v.params, sinkState[v.id], sinkIn, sinkAck(coord, v.id), counters); err != nil {
+                fail(fmt.Errorf("sink error: %s: %w", v.id, err))
+            }
+            // Keep draining so the operator chain never blocks on a dead sink.
+            for range sinkIn {
             }
+        }(v)
+    }
+
+    // --------- Operator chains ----------
+    for _, v := range p.chains {
+        in := inputOf(v)
+        out := make(chan model.Event)
+        go fanOut(out, outputsOf(v))
+        wg.Add(1)
+        go func(v *vertex, st stage) {
+            defer wg.Done()
+            defer close(out)
+            defer func() {
+                if r := recover(); r != nil {
+                    fail(fmt.Errorf("operator panic: %s: %v", v.id, r))
+                    for range in {
+                    }
+                }
+            }()
+            st.Run(drainCtx, in, out)
+        }(v, stages[v])
+    }
+
+    // --------- Sources (dynamic!) ----------
+    for _, v := range p.sources {
+        raw := make(chan model.Event)
+        out := make(chan model.Event)
+        go fanOut(out, outputsOf(v))
+        var inj *injector
+        var committed <-chan model.Offsets
+        if coord != nil {
+            inj = coord.newInjector()
+            committed = inj.committed
         }
-        close(output)
-    }()
+        var wm *watermarkGenerator
+        if spec.Watermark != nil {
+            // Validated before anything started.
+            wm, _ = newWatermarkGenerator(spec.Watermark)
+        }
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            forwardSource(runCtx, raw, out, stats, coord, inj, wm)
+            // Unblock the source if forwarding stopped first.
+            for range raw {
+            }
+        }()
+        go func(v *vertex) {
+            defer wg.Done()
+            if err := source.BuildSource(runCtx, v.params, resume, committed, raw); err != nil {
+                fail(fmt.Errorf("source error: %s: %w", v.id, err))
+            }
+        }(v)
+    }
+    if coord != nil {
+        coord.start(runCtx)
+    }
 
-    // Sink (still hardcoded to file for now)
-	go func() {
-		err := sink.BuildSink(spec.Sink.Raw, output)
-		if err != nil {
-			// Optionally: handle or log the error
-			fmt.Println("sink error:", err)
-		}
-	}()
+    wg.Wait()
+    // Cancelled before the pipeline finished on its own.
+    stopped := ctx.Err()
+    err = errors.Join(errs...)
+    if err == nil && coord != nil {
+        state := make(map[string]json.RawMessage)
+        var snapErr error
+        for _, st := range stages {
+            var s map[string]json.RawMessage
+            if s, snapErr = st.snapshot(); snapErr != nil {
+                break
+            }
+            for k, v := range s {
+                state[k] = v
+            }
+        }
+        if snapErr == nil {
+            snapErr = coord.commitFinal(state)
+        }
+        if snapErr != nil {
+            err = fmt.Errorf("final checkpoint: %w", snapErr)
+        }
+    }
+    if stopped != nil {
+        if err == nil {
+            err = stopped
+        } else {
+            err = errors.Join(err, stopped)
+        }
+    }
+    return finish(), err
+}
 
+// mergeInputs unions the inputs of a vertex, aligning checkpoint barriers
+// across them.
+func mergeInputs(ins []chan model.Event) <-chan model.Event {
+    if len(ins) == 1 {
+        return ins[0]
+    }
+    out := make(chan model.Event)
+    go func() {
+        defer close(out)
+        mergeAligned(ins, out)
+    }()
+    return out
+}
 
-    // --------- Source (dynamic!) ----------
-    // We assume spec.Source.Raw is a map[string]interface{} with the full source config.
-    // If not, adjust according to how you parse your job/pipeline spec.
-    if spec.Source.Raw == nil {
-        return fmt.Errorf("source config missing: spec.Source.Raw is nil")
+// fanOut copies every event from in to each of outs and closes them when in
+// is exhausted. Records go only to the edges for the output they were
+// emitted on; control markers go to every edge. Each branch gets its own
+// copy of the record data, so operators on one branch cannot affect another,
+// and records entering a join are tagged with the node they came from.
+func fanOut(in <-chan model.Event, outs []*queue) {
+    defer func() {
+        for _, out := range outs {
+            out.close()
+        }
+    }()
+    targets := make([]*queue, 0, len(outs))
+    for e := range in {
+        targets = targets[:0]
+        for _, out := range outs {
+            if !e.IsRecord() || out.output == e.Output {
+                targets = append(targets, out)
+            }
+        }
+        e.Output = ""
+        for i, out := range targets {
+            c := e
+            if i < len(targets)-1 {
+                c = e.Clone()
+            }
+            if c.IsRecord() {
+                c.Input = out.input
+            }
+            out.push(c)
+        }
     }
-    return source.BuildSource(spec.Source.Raw, input)
 }
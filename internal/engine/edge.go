@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+const defaultBufferSize = 256
+
+// Buffer policies decide what happens to a record that arrives at a full
+// edge.
+const (
+	policyBlock      = "block"       // wait for the consumer (backpressure)
+	policyDropOldest = "drop_oldest" // discard the oldest buffered record
+	policyDropNewest = "drop_newest" // discard the arriving record
+)
+
+// resolveBuffer applies the pipeline default and the built-in defaults to an
+// edge's buffer settings.
+func resolveBuffer(edge, pipeline *model.BufferSpec) (model.BufferSpec, error) {
+	b := model.BufferSpec{Size: defaultBufferSize, Policy: policyBlock}
+	for _, s := range []*model.BufferSpec{pipeline, edge} {
+		if s == nil {
+			continue
+		}
+		if s.Size < 0 {
+			return b, fmt.Errorf("buffer size must be positive, got %d", s.Size)
+		}
+		if s.Size > 0 {
+			b.Size = s.Size
+		}
+		switch s.Policy {
+		case "":
+		case policyBlock, policyDropOldest, policyDropNewest:
+			b.Policy = s.Policy
+		default:
+			return b, fmt.Errorf("unknown buffer policy: %s", s.Policy)
+		}
+	}
+	return b, nil
+}
+
+// queue is the bounded buffer on one edge of the graph. A single producer
+// pushes into it and a pump goroutine hands events to the consumer through
+// out. Control markers are never dropped: under a drop policy they still
+// wait for space.
+type queue struct {
+	out    chan model.Event
+	output string // the side output the edge follows; empty for the main output
+	input  string // set as the Input of every record, see link.input
+	size   int
+	policy string
+	stats  *EdgeStats
+
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	buf      []model.Event
+	closed   bool
+}
+
+func newQueue(b model.BufferSpec, stats *EdgeStats) *queue {
+	q := &queue{
+		out:    make(chan model.Event),
+		size:   b.Size,
+		policy: b.Policy,
+		stats:  stats,
+	}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+	go q.pump()
+	return q
+}
+
+// push adds e to the buffer, applying the edge policy if it is full.
+func (q *queue) push(e model.Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.buf) >= q.size && e.IsRecord() {
+		switch q.policy {
+		case policyDropNewest:
+			q.stats.dropped.Add(1)
+			return
+		case policyDropOldest:
+			q.dropOldest()
+		}
+	}
+	if len(q.buf) >= q.size {
+		start := time.Now()
+		for len(q.buf) >= q.size {
+			q.notFull.Wait()
+		}
+		q.stats.blocked.Add(int64(time.Since(start)))
+	}
+	q.buf = append(q.buf, e)
+	q.stats.depth.Store(int64(len(q.buf)))
+	q.notEmpty.Signal()
+}
+
+// dropOldest discards the oldest buffered record, leaving markers in place.
+// It must be called with q.mu held.
+func (q *queue) dropOldest() {
+	for i, e := range q.buf {
+		if e.IsRecord() {
+			q.buf = append(q.buf[:i], q.buf[i+1:]...)
+			q.stats.dropped.Add(1)
+			return
+		}
+	}
+}
+
+// close marks the end of input; out is closed once the buffer is drained.
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.notEmpty.Signal()
+	q.mu.Unlock()
+}
+
+func (q *queue) pump() {
+	defer close(q.out)
+	for {
+		q.mu.Lock()
+		for len(q.buf) == 0 && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if len(q.buf) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		e := q.buf[0]
+		q.buf[0] = model.Event{}
+		q.buf = q.buf[1:]
+		q.stats.depth.Store(int64(len(q.buf)))
+		q.notFull.Signal()
+		q.mu.Unlock()
+		q.out <- e
+	}
+}
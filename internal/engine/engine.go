@@ -1,26 +1,203 @@
 package engine
 
 import (
+    "context"
+    "encoding/json"
+    "fmt"
     "goxstream/internal/model"
     "goxstream/internal/operator"
+    "time"
 )
 
+// stage moves events from input to output; it is either a plain Pipeline or
+// a keyedPipeline that fans a chain out over several workers.
+type stage interface {
+    Run(ctx context.Context, input <-chan model.Event, output chan<- model.Event)
+    snapshot() (map[string]json.RawMessage, error)
+    restore(state map[string]json.RawMessage) error
+    attach(c *coordinator)
+    // report registers the counters of the stage's operators with s.
+    report(s *Stats)
+    // acks is the number of checkpoint acknowledgements the stage sends per barrier.
+    acks() int
+}
+
 type Pipeline struct {
     Operators []operator.Operator
+
+    checkpoints *coordinator // nil when checkpointing is disabled
+    name        string       // prefixes checkpoint keys of parallel workers
+    keys        []string     // checkpoint key of each operator (its node ID)
+}
+
+// Run pushes every input event through the operator chain until input is
+// closed or ctx is cancelled. On cancellation it stops pulling new events;
+// the event being processed is still delivered to output. Either way the
+// operators are flushed before Run returns.
+func (p *Pipeline) Run(ctx context.Context, input <-chan model.Event, output chan<- model.Event) {
+    p.consume(ctx, input, output)
+    p.flush(output)
+}
+
+func (p *Pipeline) consume(ctx context.Context, input <-chan model.Event, output chan<- model.Event) {
+    var tick <-chan time.Time
+    if p.hasTimers() {
+        ticker := time.NewTicker(timerResolution)
+        defer ticker.Stop()
+        tick = ticker.C
+    }
+    for {
+        var event model.Event
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-tick:
+            p.timers(output, now)
+            continue
+        case e, ok := <-input:
+            if !ok {
+                return
+            }
+            event = e
+        }
+        if event.Kind == model.KindBarrier {
+            if p.checkpoints != nil {
+                state, err := p.snapshot()
+                p.checkpoints.ack(event.Checkpoint, state, err)
+            }
+            output <- event
+            continue
+        }
+        if event.Kind == model.KindWatermark {
+            p.watermark(output, event.Timestamp)
+            output <- event
+            continue
+        }
+        p.emit(output, p.process(0, []model.Event{event}))
+    }
+}
+
+// process runs events through the operators starting at index from.
+func (p *Pipeline) process(from int, events []model.Event) []model.Event {
+    for _, op := range p.Operators[from:] {
+        next := []model.Event{}
+        for _, e := range events {
+            if e.Output != "" {
+                // Side outputs bypass the rest of the chain.
+                next = append(next, e)
+                continue
+            }
+            next = append(next, op.Process(e)...)
+        }
+        events = next
+    }
+    return events
+}
+
+// flush drains every Flusher in chain order, pushing whatever it releases
+// through the operators downstream of it so later windows see it before
+// they are flushed themselves.
+func (p *Pipeline) flush(output chan<- model.Event) {
+    for i, op := range p.Operators {
+        if f, ok := op.(operator.Flusher); ok {
+            p.emit(output, p.process(i+1, f.Flush()))
+        }
+    }
+}
+
+// watermark advances every WatermarkHandler in chain order. Like flush,
+// whatever a window fires goes through the operators downstream of it before
+// they see the watermark themselves.
+func (p *Pipeline) watermark(output chan<- model.Event, wm time.Time) {
+    for i, op := range p.Operators {
+        if h, ok := op.(operator.WatermarkHandler); ok {
+            p.emit(output, p.process(i+1, h.OnWatermark(wm)))
+        }
+    }
 }
 
-func (p *Pipeline) Run(input <-chan model.Event, output chan<- model.Event) {
-    for event := range input {
-        events := []model.Event{event}
-        for _, op := range p.Operators {
-            next := []model.Event{}
-            for _, e := range events {
-                next = append(next, op.Process(e)...)
+// timerResolution is how often operators acting on processing time are
+// called.
+const timerResolution = 100 * time.Millisecond
+
+func (p *Pipeline) hasTimers() bool {
+    for _, op := range p.Operators {
+        if _, ok := op.(operator.Timer); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// timers calls every Timer in chain order, pushing whatever fires through
+// the operators downstream of it.
+func (p *Pipeline) timers(output chan<- model.Event, now time.Time) {
+    for i, op := range p.Operators {
+        if t, ok := op.(operator.Timer); ok {
+            p.emit(output, p.process(i+1, t.OnTimer(now)))
+        }
+    }
+}
+
+func (p *Pipeline) emit(output chan<- model.Event, events []model.Event) {
+    for _, out := range events {
+        output <- out
+    }
+}
+
+func (p *Pipeline) attach(c *coordinator) { p.checkpoints = c }
+
+func (p *Pipeline) report(s *Stats) {
+    for i, op := range p.Operators {
+        if c, ok := op.(operator.Counter); ok {
+            s.addOperator(p.operatorKey(i, op), c)
+        }
+    }
+}
+
+func (p *Pipeline) acks() int { return 1 }
+
+func (p *Pipeline) operatorKey(i int, op operator.Operator) string {
+    key := fmt.Sprintf("%d-%s", i, op.Name())
+    if i < len(p.keys) {
+        key = p.keys[i]
+    }
+    if p.name != "" {
+        key = p.name + "/" + key
+    }
+    return key
+}
+
+// snapshot captures the state of every Snapshotter in the chain.
+func (p *Pipeline) snapshot() (map[string]json.RawMessage, error) {
+    state := make(map[string]json.RawMessage)
+    for i, op := range p.Operators {
+        if s, ok := op.(operator.Snapshotter); ok {
+            data, err := s.Snapshot()
+            if err != nil {
+                return nil, fmt.Errorf("snapshot %s: %w", p.operatorKey(i, op), err)
             }
-            events = next
+            state[p.operatorKey(i, op)] = data
+        }
+    }
+    return state, nil
+}
+
+// restore loads operator state from a checkpoint. Operators without saved
+// state start empty.
+func (p *Pipeline) restore(state map[string]json.RawMessage) error {
+    for i, op := range p.Operators {
+        s, ok := op.(operator.Snapshotter)
+        if !ok {
+            continue
+        }
+        data, ok := state[p.operatorKey(i, op)]
+        if !ok {
+            continue
         }
-        for _, out := range events {
-            output <- out
+        if err := s.Restore(data); err != nil {
+            return fmt.Errorf("restore %s: %w", p.operatorKey(i, op), err)
         }
     }
+    return nil
 }
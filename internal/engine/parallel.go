@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"goxstream/internal/model"
+	"goxstream/internal/operator"
+)
+
+// keyedPipeline runs the operators up to and including the first key_by
+// serially, then hash-partitions records by key across parallel copies of
+// the rest of the chain. Each worker owns its own operator state, and all
+// records for a key go to the same worker in order.
+type keyedPipeline struct {
+	head    *Pipeline
+	keyBy   *operator.KeyByOperator
+	workers []*Pipeline
+}
+
+// newStage builds a chain of operator nodes. With parallelism > 1 and a
+// key_by operator in the chain, the operators after key_by are built once
+// per worker, except that the first worker's operators that are
+// Replicators are replicated for the others.
+func newStage(nodes []model.NodeSpec, parallelism int) (stage, error) {
+	build := func(nodes []model.NodeSpec, first *Pipeline) (*Pipeline, error) {
+		p := &Pipeline{}
+		for i, n := range nodes {
+			if first != nil {
+				if r, ok := first.Operators[i].(operator.Replicator); ok {
+					p.Operators = append(p.Operators, r.Replicate())
+					p.keys = append(p.keys, n.ID)
+					continue
+				}
+			}
+			op, err := operator.BuildOperator(model.OperatorSpec{Type: n.Type, Params: n.Params})
+			if err != nil {
+				return nil, fmt.Errorf("operator build error: %s: %w", n.ID, err)
+			}
+			p.Operators = append(p.Operators, op)
+			p.keys = append(p.keys, n.ID)
+		}
+		return p, nil
+	}
+
+	keyAt := -1
+	for i, n := range nodes {
+		if n.Type == "key_by" {
+			keyAt = i
+			break
+		}
+	}
+	if parallelism <= 1 || keyAt < 0 {
+		return build(nodes, nil)
+	}
+
+	head, err := build(nodes[:keyAt+1], nil)
+	if err != nil {
+		return nil, err
+	}
+	kp := &keyedPipeline{head: head, keyBy: head.Operators[keyAt].(*operator.KeyByOperator)}
+	var first *Pipeline
+	for w := 0; w < parallelism; w++ {
+		worker, err := build(nodes[keyAt+1:], first)
+		if err != nil {
+			return nil, err
+		}
+		worker.name = fmt.Sprintf("w%d", w)
+		kp.workers = append(kp.workers, worker)
+		if first == nil {
+			first = worker
+		}
+	}
+	return kp, nil
+}
+
+func (kp *keyedPipeline) Run(ctx context.Context, input <-chan model.Event, output chan<- model.Event) {
+	n := len(kp.workers)
+	headOut := make(chan model.Event)
+	ins := make([]chan model.Event, n)
+	outs := make([]chan model.Event, n)
+	for i := range kp.workers {
+		ins[i] = make(chan model.Event)
+		outs[i] = make(chan model.Event)
+	}
+
+	// A panic in any goroutine stops the head like a cancellation would, and
+	// is re-raised once everything has shut down.
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var panicked interface{}
+	recordPanic := func(r interface{}) {
+		mu.Lock()
+		if panicked == nil {
+			panicked = r
+		}
+		mu.Unlock()
+		stop()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(headOut)
+		defer func() {
+			if r := recover(); r != nil {
+				recordPanic(r)
+			}
+		}()
+		kp.head.Run(runCtx, input, headOut)
+	}()
+
+	// Workers are not cancelled directly: once the head stops reading input
+	// it closes headOut, which closes every worker input in turn, so events
+	// already routed are still drained and flushed.
+	for i, w := range kp.workers {
+		wg.Add(1)
+		go func(w *Pipeline, in <-chan model.Event, out chan<- model.Event) {
+			defer wg.Done()
+			defer close(out)
+			defer func() {
+				if r := recover(); r != nil {
+					recordPanic(r)
+					for range in {
+					}
+				}
+			}()
+			w.Run(context.WithoutCancel(ctx), in, out)
+		}(w, ins[i], outs[i])
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		kp.route(headOut, ins)
+	}()
+
+	mergeAligned(outs, output)
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// route sends each record to the worker owning its key and broadcasts
+// barriers to every worker.
+func (kp *keyedPipeline) route(in <-chan model.Event, outs []chan model.Event) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+	for e := range in {
+		if !e.IsRecord() {
+			for _, out := range outs {
+				out <- e
+			}
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(kp.keyBy.Key(e)))
+		outs[h.Sum32()%uint32(len(outs))] <- e
+	}
+}
+
+// mergeAligned merges the worker outputs into output. When a worker delivers
+// a barrier, its channel is not read again until every worker has delivered
+// the same barrier, which is then forwarded once. A closed worker counts as
+// aligned. Watermarks are combined: the minimum over the open inputs is
+// forwarded whenever it advances.
+func mergeAligned(ins []chan model.Event, output chan<- model.Event) {
+	type item struct {
+		from int
+		e    model.Event
+		ok   bool
+	}
+	n := len(ins)
+	items := make(chan item)
+	resume := make([]chan struct{}, n)
+	for i, in := range ins {
+		resume[i] = make(chan struct{})
+		go func(i int, in <-chan model.Event) {
+			for e := range in {
+				items <- item{from: i, e: e, ok: true}
+				if e.Kind == model.KindBarrier {
+					<-resume[i]
+				}
+			}
+			items <- item{from: i}
+		}(i, in)
+	}
+
+	open := n
+	var blocked []int
+	var barrier model.Event
+	release := func() {
+		output <- barrier
+		for _, i := range blocked {
+			resume[i] <- struct{}{}
+		}
+		blocked = nil
+	}
+	closed := make([]bool, n)
+	watermarks := make([]time.Time, n)
+	var watermark time.Time
+	forwardWatermark := func() {
+		var min time.Time
+		seen := false
+		for i, wm := range watermarks {
+			if closed[i] {
+				continue
+			}
+			if !seen || wm.Before(min) {
+				min, seen = wm, true
+			}
+		}
+		// An input without a watermark yet holds the others back.
+		if seen && min.After(watermark) {
+			watermark = min
+			output <- model.Event{Kind: model.KindWatermark, Timestamp: min}
+		}
+	}
+	for open > 0 {
+		it := <-items
+		switch {
+		case !it.ok:
+			open--
+			closed[it.from] = true
+			if len(blocked) > 0 && len(blocked) == open {
+				release()
+			}
+			forwardWatermark()
+		case it.e.Kind == model.KindWatermark:
+			if it.e.Timestamp.After(watermarks[it.from]) {
+				watermarks[it.from] = it.e.Timestamp
+			}
+			forwardWatermark()
+		case it.e.Kind == model.KindBarrier:
+			barrier = it.e
+			blocked = append(blocked, it.from)
+			if len(blocked) == open {
+				release()
+			}
+		default:
+			output <- it.e
+		}
+	}
+}
+
+func (kp *keyedPipeline) snapshot() (map[string]json.RawMessage, error) {
+	state, err := kp.head.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range kp.workers {
+		ws, err := w.snapshot()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range ws {
+			state[k] = v
+		}
+	}
+	return state, nil
+}
+
+func (kp *keyedPipeline) restore(state map[string]json.RawMessage) error {
+	if err := kp.head.restore(state); err != nil {
+		return err
+	}
+	for _, w := range kp.workers {
+		if err := w.restore(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kp *keyedPipeline) attach(c *coordinator) {
+	kp.head.attach(c)
+	for _, w := range kp.workers {
+		w.attach(c)
+	}
+}
+
+func (kp *keyedPipeline) report(s *Stats) {
+	kp.head.report(s)
+	for _, w := range kp.workers {
+		w.report(s)
+	}
+}
+
+func (kp *keyedPipeline) acks() int { return 1 + len(kp.workers) }
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goxstream/internal/operator"
+)
+
+// Stats holds live counters for a running pipeline. It is safe to read
+// while the pipeline is still running.
+type Stats struct {
+	RecordsIn  atomic.Int64 // events received from the source
+	RecordsOut atomic.Int64 // events handed to the sink
+
+	mu        sync.Mutex
+	edges     []*EdgeStats
+	operators []operatorCounters
+	sinks     []operatorCounters
+}
+
+type operatorCounters struct {
+	node string
+	op   operator.Counter
+}
+
+// OperatorStat is a point-in-time view of an operator's or a sink's own
+// counters.
+type OperatorStat struct {
+	Node     string           `json:"node"`
+	Counters map[string]int64 `json:"counters"`
+}
+
+// EdgeStats holds live counters for one buffered edge between stages.
+type EdgeStats struct {
+	from, to string
+	buffer   int
+	policy   string
+
+	depth   atomic.Int64 // events currently buffered
+	blocked atomic.Int64 // nanoseconds the producer spent waiting for space
+	dropped atomic.Int64 // records discarded by a drop policy
+}
+
+// EdgeStat is a point-in-time view of an edge's counters.
+type EdgeStat struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Buffer  int           `json:"buffer"`
+	Policy  string        `json:"policy"`
+	Depth   int64         `json:"depth"`
+	Blocked time.Duration `json:"blocked"`
+	Dropped int64         `json:"dropped"`
+}
+
+func (s *Stats) addEdge(from, to string, buffer int, policy string) *EdgeStats {
+	e := &EdgeStats{from: from, to: to, buffer: buffer, policy: policy}
+	s.mu.Lock()
+	s.edges = append(s.edges, e)
+	s.mu.Unlock()
+	return e
+}
+
+// Edges returns the current counters of every edge, in graph order.
+func (s *Stats) Edges() []EdgeStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []EdgeStat
+	for _, e := range s.edges {
+		out = append(out, EdgeStat{
+			From:    e.from,
+			To:      e.to,
+			Buffer:  e.buffer,
+			Policy:  e.policy,
+			Depth:   e.depth.Load(),
+			Blocked: time.Duration(e.blocked.Load()),
+			Dropped: e.dropped.Load(),
+		})
+	}
+	return out
+}
+
+func (s *Stats) addOperator(node string, op operator.Counter) {
+	s.mu.Lock()
+	s.operators = append(s.operators, operatorCounters{node: node, op: op})
+	s.mu.Unlock()
+}
+
+// Operators returns the current counters of every operator that keeps any,
+// by node ID. Each worker of a parallel chain is reported on its own.
+func (s *Stats) Operators() []OperatorStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []OperatorStat
+	for _, o := range s.operators {
+		out = append(out, OperatorStat{Node: o.node, Counters: o.op.Counters()})
+	}
+	return out
+}
+
+func (s *Stats) addSink(node string, c operator.Counter) {
+	s.mu.Lock()
+	s.sinks = append(s.sinks, operatorCounters{node: node, op: c})
+	s.mu.Unlock()
+}
+
+// Sinks returns the current counters of every sink that keeps any, such as
+// the values a file sink dropped, by node ID.
+func (s *Stats) Sinks() []OperatorStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []OperatorStat
+	for _, o := range s.sinks {
+		if counters := o.op.Counters(); len(counters) > 0 {
+			out = append(out, OperatorStat{Node: o.node, Counters: counters})
+		}
+	}
+	return out
+}
+
+// RunStats summarises a finished pipeline run.
+type RunStats struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Duration   time.Duration  `json:"duration"`
+	RecordsIn  int64          `json:"records_in"`
+	RecordsOut int64          `json:"records_out"`
+	Edges      []EdgeStat     `json:"edges,omitempty"`
+	Operators  []OperatorStat `json:"operators,omitempty"`
+	Sinks      []OperatorStat `json:"sinks,omitempty"`
+}
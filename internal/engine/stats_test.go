@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+
+	"goxstream/internal/model"
+)
+
+// Values a sink drops show up in the run stats rather than only in its log.
+func TestRunStatsSinkCounters(t *testing.T) {
+	dir := t.TempDir()
+	in := writeInput(t, dir, "in.csv", "v,x", "a,1", "b,2")
+	rs := run(t, model.PipelineSpec{
+		Source: model.SourceSpec{Raw: map[string]interface{}{"type": "file", "path": in}},
+		Sink: model.SinkSpec{Raw: map[string]interface{}{
+			"type": "file", "path": filepath.Join(dir, "out.csv"), "columns": []interface{}{"v"},
+		}},
+	})
+	if len(rs.Sinks) != 1 || rs.Sinks[0].Node != nodeSink || rs.Sinks[0].Counters["dropped:x"] != 2 {
+		t.Errorf("sink stats = %+v, want 2 values of x dropped", rs.Sinks)
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// watermarkGenerator derives the watermark of one source from the records it
+// emits. The watermark never moves backwards.
+type watermarkGenerator struct {
+	punctuated bool
+	bound      time.Duration // bounded: how far the watermark trails the max event time
+	field      string        // punctuated: field carrying the watermark
+	idle       time.Duration // 0: never advance an idle source
+
+	current      time.Time // last watermark emitted
+	maxEventTime time.Time
+	lastRecord   time.Time // wall clock time of the last record
+}
+
+func newWatermarkGenerator(spec *model.WatermarkSpec) (*watermarkGenerator, error) {
+	g := &watermarkGenerator{field: spec.Field, lastRecord: time.Now()}
+	switch spec.Strategy {
+	case "", "bounded":
+	case "punctuated":
+		if spec.Field == "" {
+			return nil, fmt.Errorf("punctuated watermark expects field")
+		}
+		g.punctuated = true
+	default:
+		return nil, fmt.Errorf("unknown watermark strategy: %q", spec.Strategy)
+	}
+	if spec.MaxOutOfOrderness != "" {
+		d, err := time.ParseDuration(spec.MaxOutOfOrderness)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid watermark max_out_of_orderness: %q", spec.MaxOutOfOrderness)
+		}
+		g.bound = d
+	}
+	if spec.IdleTimeout != "" {
+		d, err := time.ParseDuration(spec.IdleTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid watermark idle_timeout: %q", spec.IdleTimeout)
+		}
+		g.idle = d
+	}
+	return g, nil
+}
+
+// onRecord updates the watermark with a record the source emitted and
+// returns a watermark marker if it advanced.
+func (g *watermarkGenerator) onRecord(e model.Event, now time.Time) (model.Event, bool) {
+	g.lastRecord = now
+	if e.Timestamp.After(g.maxEventTime) {
+		g.maxEventTime = e.Timestamp
+	}
+	if g.punctuated {
+		t, ok := punctuation(e.Data[g.field])
+		if !ok {
+			return model.Event{}, false
+		}
+		return g.advance(t)
+	}
+	return g.advance(g.maxEventTime.Add(-g.bound))
+}
+
+// onIdle advances the watermark of a source that has been idle for the idle
+// timeout: first to its max event time, then with the wall clock.
+func (g *watermarkGenerator) onIdle(now time.Time) (model.Event, bool) {
+	idleFor := now.Sub(g.lastRecord)
+	if g.idle == 0 || idleFor < g.idle || g.maxEventTime.IsZero() {
+		return model.Event{}, false
+	}
+	return g.advance(g.maxEventTime.Add(idleFor - g.idle))
+}
+
+func (g *watermarkGenerator) advance(t time.Time) (model.Event, bool) {
+	if !t.After(g.current) {
+		return model.Event{}, false
+	}
+	g.current = t
+	return model.Event{Kind: model.KindWatermark, Timestamp: t}, true
+}
+
+func punctuation(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		ts, err := time.Parse(time.RFC3339, t)
+		return ts, err == nil
+	}
+	return time.Time{}, false
+}
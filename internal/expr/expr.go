@@ -0,0 +1,191 @@
+// Package expr implements the small expression language used by the map and
+// filter operators, e.g. `score > 10 && city != 'Paris'` or `score * 1.1`.
+//
+// Field names refer to the event's data (quote unusual names with
+// backticks); literals are numbers, 'strings', true, false and null. The
+// operators are arithmetic (+ - * / %), comparison (== != < <= > >=) and
+// boolean logic (&& || !, or and/or/not), plus the functions in funcs.go.
+//
+// Values from CSV files are strings, so numeric-looking strings are
+// compared and computed as numbers. Missing fields and failed operations
+// (division by zero, bad casts) yield null, which propagates through
+// arithmetic and makes ordering comparisons null; a null condition is false.
+package expr
+
+import (
+	"fmt"
+	"math"
+)
+
+// Program is a compiled expression, safe for concurrent use.
+type Program struct {
+	src  string
+	root node
+}
+
+// Compile parses src once so it can be evaluated against every event.
+func Compile(src string) (*Program, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseExpr(1)
+	if err == nil && p.peek().kind != tokEOF {
+		t := p.peek()
+		err = fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+func (p *Program) String() string { return p.src }
+
+// Eval evaluates the expression against an event's data.
+func (p *Program) Eval(data map[string]interface{}) interface{} {
+	return p.root.eval(data)
+}
+
+// Match evaluates the expression as a condition.
+func (p *Program) Match(data map[string]interface{}) bool {
+	return Truthy(p.Eval(data))
+}
+
+type node interface {
+	eval(data map[string]interface{}) interface{}
+}
+
+type literal struct{ v interface{} }
+
+func (n *literal) eval(map[string]interface{}) interface{} { return n.v }
+
+type field struct{ name string }
+
+func (n *field) eval(data map[string]interface{}) interface{} { return normalize(data[n.name]) }
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (n *unary) eval(data map[string]interface{}) interface{} {
+	v := n.x.eval(data)
+	if n.op == "!" {
+		return !Truthy(v)
+	}
+	switch x := toNumber(v).(type) {
+	case int64:
+		return -x
+	case float64:
+		return -x
+	}
+	return nil
+}
+
+type logical struct {
+	and  bool
+	l, r node
+}
+
+func (n *logical) eval(data map[string]interface{}) interface{} {
+	l := Truthy(n.l.eval(data))
+	if n.and != l {
+		return l // false && ..., true || ...
+	}
+	return Truthy(n.r.eval(data))
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (n *binary) eval(data map[string]interface{}) interface{} {
+	l, r := n.l.eval(data), n.r.eval(data)
+	switch n.op {
+	case "==":
+		return Equal(l, r)
+	case "!=":
+		return !Equal(l, r)
+	case "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return nil
+		}
+		switch n.op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	}
+	if l == nil || r == nil {
+		return nil
+	}
+	ln, rn := toNumber(l), toNumber(r)
+	if ln == nil || rn == nil {
+		if n.op == "+" {
+			return toString(l) + toString(r)
+		}
+		return nil
+	}
+	return arith(n.op, ln, rn)
+}
+
+func arith(op string, l, r interface{}) interface{} {
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt && op != "/" {
+		switch op {
+		case "+":
+			return li + ri
+		case "-":
+			return li - ri
+		case "*":
+			return li * ri
+		case "%":
+			if ri == 0 {
+				return nil
+			}
+			return li % ri
+		}
+	}
+	lf, rf := toFloat(l), toFloat(r)
+	switch op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	case "/":
+		if rf == 0 {
+			return nil
+		}
+		return lf / rf
+	case "%":
+		if rf == 0 {
+			return nil
+		}
+		return math.Mod(lf, rf)
+	}
+	return nil
+}
+
+type call struct {
+	fn   *function
+	args []node
+}
+
+func (n *call) eval(data map[string]interface{}) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		args[i] = a.eval(data)
+	}
+	return n.fn.call(args)
+}
@@ -0,0 +1,129 @@
+package expr
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"city == 'Paris", "unterminated string at position 9"},
+		{"`first name == 'x'", "unterminated field name at position 1"},
+		{"score # 2", "unexpected character '#' at position 7"},
+		{"score >", "unexpected end of expression"},
+		{"(score + 1", "expected ')' at position 11"},
+		{"score 1", "unexpected \"1\" at position 7"},
+		{"and", "unexpected \"and\" at position 1"},
+		{"1.2.3", "invalid number \"1.2.3\" at position 1"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want error containing %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	data := map[string]interface{}{
+		"score":      "15",
+		"count":      3,
+		"ratio":      0.5,
+		"city":       "Paris",
+		"active":     true,
+		"first name": "Ada",
+		"nan":        "NaN",
+		"inf":        "Inf",
+		"fnan":       math.NaN(),
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		// Precedence and associativity.
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"7 / 2", 3.5},
+		{"7 % 4 + 1", int64(4)},
+		{"-2 * 3", int64(-6)},
+		{"1 + 2 == 3", true},
+		{"1 < 2 == true", true},
+		{"true || false && false", true},
+		{"not false and false", false},
+		{"!(1 > 2) && 2 >= 2", true},
+
+		// Null propagation.
+		{"missing + 1", nil},
+		{"-missing", nil},
+		{"missing > 1", nil},
+		{"missing == null", true},
+		{"missing != 0", true},
+		{"1 / 0", nil},
+		{"count % 0", nil},
+		{"missing || active", true},
+
+		// Cross-type comparison and arithmetic.
+		{"score == 15", true},
+		{"score > 9", true},
+		{"score + count", int64(18)},
+		{"score * ratio", 7.5},
+		{"city + '!'", "Paris!"},
+		{"city > 'London'", true},
+		{"city == 1", false},
+		{"active == true", true},
+		{"'abc' < 'abd'", true},
+		{"`first name` == 'Ada'", true},
+
+		// NaN and Inf strings are not numbers; a NaN value equals nothing.
+		{"nan == 1", false},
+		{"nan == 'NaN'", true},
+		{"inf + 1", "Inf1"},
+		{"nan + 1", "NaN1"},
+		{"fnan == fnan", false},
+		{"fnan != 1", true},
+		{"fnan < 1", nil},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		if got := p.Eval(data); got != tt.want {
+			t.Errorf("Eval(%q) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		data map[string]interface{}
+		want bool
+	}{
+		{"score > 10 && city != 'Paris'", map[string]interface{}{"score": "12", "city": "Lyon"}, true},
+		{"score > 10 && city != 'Paris'", map[string]interface{}{"score": "12", "city": "Paris"}, false},
+		{"score > 10", map[string]interface{}{}, false},
+		{"flag", map[string]interface{}{"flag": "false"}, false},
+		{"flag", map[string]interface{}{"flag": "yes"}, true},
+		{"flag", map[string]interface{}{"flag": 0}, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		if got := p.Match(tt.data); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.src, tt.data, got, tt.want)
+		}
+	}
+}
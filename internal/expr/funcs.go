@@ -0,0 +1,199 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type function struct {
+	name     string
+	min, max int // accepted argument counts; max < 0 means variadic
+	call     func(args []interface{}) interface{}
+}
+
+func (f *function) arity() string {
+	switch {
+	case f.min == f.max:
+		return fmt.Sprintf("expects %d argument(s)", f.min)
+	case f.max < 0:
+		return fmt.Sprintf("expects at least %d argument(s)", f.min)
+	}
+	return fmt.Sprintf("expects %d to %d arguments", f.min, f.max)
+}
+
+var functions = map[string]*function{}
+
+func register(name string, min, max int, call func(args []interface{}) interface{}) {
+	functions[name] = &function{name: name, min: min, max: max, call: call}
+}
+
+// str wraps a string function so that a null argument yields null.
+func str(fn func(s string, args []interface{}) interface{}) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if args[0] == nil {
+			return nil
+		}
+		return fn(toString(args[0]), args[1:])
+	}
+}
+
+// num wraps a numeric function so that a null or non-numeric argument
+// yields null.
+func num(fn func(n interface{}) interface{}) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		n := toNumber(args[0])
+		if n == nil {
+			return nil
+		}
+		return fn(n)
+	}
+}
+
+func init() {
+	// Strings
+	register("lower", 1, 1, str(func(s string, _ []interface{}) interface{} { return strings.ToLower(s) }))
+	register("upper", 1, 1, str(func(s string, _ []interface{}) interface{} { return strings.ToUpper(s) }))
+	register("trim", 1, 1, str(func(s string, _ []interface{}) interface{} { return strings.TrimSpace(s) }))
+	register("len", 1, 1, str(func(s string, _ []interface{}) interface{} { return int64(len([]rune(s))) }))
+	register("contains", 2, 2, str(func(s string, a []interface{}) interface{} { return strings.Contains(s, toString(a[0])) }))
+	register("starts_with", 2, 2, str(func(s string, a []interface{}) interface{} { return strings.HasPrefix(s, toString(a[0])) }))
+	register("ends_with", 2, 2, str(func(s string, a []interface{}) interface{} { return strings.HasSuffix(s, toString(a[0])) }))
+	register("replace", 3, 3, str(func(s string, a []interface{}) interface{} {
+		return strings.ReplaceAll(s, toString(a[0]), toString(a[1]))
+	}))
+	register("substr", 2, 3, str(func(s string, a []interface{}) interface{} {
+		rs := []rune(s)
+		start, ok := toNumber(a[0]).(int64)
+		if !ok {
+			return nil
+		}
+		start = clamp(start, 0, int64(len(rs)))
+		end := int64(len(rs))
+		if len(a) > 1 {
+			n, ok := toNumber(a[1]).(int64)
+			if !ok {
+				return nil
+			}
+			end = clamp(start+n, start, end)
+		}
+		return string(rs[start:end])
+	}))
+	register("concat", 1, -1, func(args []interface{}) interface{} {
+		var sb strings.Builder
+		for _, a := range args {
+			sb.WriteString(toString(a))
+		}
+		return sb.String()
+	})
+
+	// Casts
+	register("int", 1, 1, func(args []interface{}) interface{} {
+		if b, ok := normalize(args[0]).(bool); ok {
+			if b {
+				return int64(1)
+			}
+			return int64(0)
+		}
+		switch n := toNumber(args[0]).(type) {
+		case int64:
+			return n
+		case float64:
+			return int64(n)
+		}
+		return nil
+	})
+	register("float", 1, 1, num(func(n interface{}) interface{} { return toFloat(n) }))
+	register("string", 1, 1, func(args []interface{}) interface{} {
+		if args[0] == nil {
+			return nil
+		}
+		return toString(args[0])
+	})
+	register("bool", 1, 1, func(args []interface{}) interface{} {
+		if args[0] == nil {
+			return nil
+		}
+		return Truthy(args[0])
+	})
+
+	// Nulls and conditionals
+	register("is_null", 1, 1, func(args []interface{}) interface{} { return args[0] == nil })
+	register("coalesce", 1, -1, func(args []interface{}) interface{} {
+		for _, a := range args {
+			if a != nil {
+				return a
+			}
+		}
+		return nil
+	})
+	register("if", 3, 3, func(args []interface{}) interface{} {
+		if Truthy(args[0]) {
+			return args[1]
+		}
+		return args[2]
+	})
+
+	// Numbers
+	register("abs", 1, 1, num(func(n interface{}) interface{} {
+		if i, ok := n.(int64); ok {
+			if i < 0 {
+				return -i
+			}
+			return i
+		}
+		return math.Abs(toFloat(n))
+	}))
+	register("floor", 1, 1, num(func(n interface{}) interface{} { return math.Floor(toFloat(n)) }))
+	register("ceil", 1, 1, num(func(n interface{}) interface{} { return math.Ceil(toFloat(n)) }))
+	register("round", 1, 2, func(args []interface{}) interface{} {
+		n := toNumber(args[0])
+		if n == nil {
+			return nil
+		}
+		places := int64(0)
+		if len(args) > 1 {
+			p, ok := toNumber(args[1]).(int64)
+			if !ok {
+				return nil
+			}
+			places = p
+		}
+		scale := math.Pow(10, float64(places))
+		return math.Round(toFloat(n)*scale) / scale
+	})
+	register("min", 1, -1, func(args []interface{}) interface{} { return extreme(args, -1) })
+	register("max", 1, -1, func(args []interface{}) interface{} { return extreme(args, 1) })
+}
+
+func clamp(v, lo, hi int64) int64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// extreme returns the smallest (sign -1) or largest (sign 1) non-null
+// argument.
+func extreme(args []interface{}, sign int) interface{} {
+	var best interface{}
+	for _, a := range args {
+		if a == nil {
+			continue
+		}
+		if best == nil {
+			best = a
+			continue
+		}
+		if c, ok := compare(a, best); ok && c*sign > 0 {
+			best = a
+		}
+	}
+	if n := toNumber(best); n != nil {
+		return n
+	}
+	return best
+}
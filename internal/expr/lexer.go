@@ -0,0 +1,127 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string // operator, identifier or literal text; strings are unquoted
+	pos  int    // 1-based offset in the source
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators lists the symbolic operators, longest first.
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "="}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{tokLParen, "(", pos})
+			i++
+		case r == ')':
+			toks = append(toks, token{tokRParen, ")", pos})
+			i++
+		case r == ',':
+			toks = append(toks, token{tokComma, ",", pos})
+			i++
+		case r == '\'' || r == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at position %d", pos)
+			}
+			toks = append(toks, token{tokString, sb.String(), pos})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			if j < len(rs) && (rs[j] == 'e' || rs[j] == 'E') {
+				k := j + 1
+				if k < len(rs) && (rs[k] == '+' || rs[k] == '-') {
+					k++
+				}
+				if k < len(rs) && unicode.IsDigit(rs[k]) {
+					for j = k; j < len(rs) && unicode.IsDigit(rs[j]); j++ {
+					}
+				}
+			}
+			toks = append(toks, token{tokNumber, string(rs[i:j]), pos})
+			i = j
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, string(rs[i:j]), pos})
+			i = j
+		case r == '`':
+			// `field name` quotes a field that is not a plain identifier.
+			j := i + 1
+			for j < len(rs) && rs[j] != '`' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated field name at position %d", pos)
+			}
+			toks = append(toks, token{tokIdent, string(rs[i : j+1]), pos})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(string(rs[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, pos)
+			}
+			i += len(op)
+			if op == "=" {
+				op = "=="
+			}
+			toks = append(toks, token{tokOp, op, pos})
+		}
+	}
+	return append(toks, token{tokEOF, "", len(rs) + 1}), nil
+}
@@ -0,0 +1,158 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// binary operator precedence, lowest first.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// keyword spellings of the boolean operators.
+var keywords = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// op returns the operator a token stands for, if any.
+func (p *parser) op(t token) string {
+	if t.kind == tokOp {
+		return t.text
+	}
+	if t.kind == tokIdent {
+		return keywords[strings.ToLower(t.text)]
+	}
+	return ""
+}
+
+func (p *parser) parseExpr(minPrec int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.op(p.peek())
+		prec, ok := precedence[op]
+		if !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "&&", "||":
+			left = &logical{and: op == "&&", l: left, r: right}
+		default:
+			left = &binary{op: op, l: left, r: right}
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if op := p.op(p.peek()); op == "!" || op == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{v: n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return &literal{v: f}, nil
+	case tokString:
+		return &literal{v: t.text}, nil
+	case tokLParen:
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if rp := p.next(); rp.kind != tokRParen {
+			return nil, fmt.Errorf("expected ')' at position %d, got %s", rp.pos, rp)
+		}
+		return x, nil
+	case tokIdent:
+		if strings.HasPrefix(t.text, "`") {
+			return &field{name: strings.Trim(t.text, "`")}, nil
+		}
+		if p.peek().kind == tokLParen {
+			return p.parseCall(t)
+		}
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literal{v: true}, nil
+		case "false":
+			return &literal{v: false}, nil
+		case "null", "nil":
+			return &literal{v: nil}, nil
+		}
+		if _, kw := keywords[strings.ToLower(t.text)]; kw {
+			break
+		}
+		return &field{name: t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", name.text, name.pos)
+	}
+	p.next() // (
+	var args []node
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if rp := p.next(); rp.kind != tokRParen {
+		return nil, fmt.Errorf("expected ')' at position %d, got %s", rp.pos, rp)
+	}
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("%s at position %d: %s", name.text, name.pos, fn.arity())
+	}
+	return &call{fn: fn, args: args}, nil
+}
@@ -0,0 +1,143 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// normalize maps the Go types found in event data onto the expression
+// value types: nil, bool, int64, float64 and string.
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, bool, int64, float64, string:
+		return x
+	case int:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case float32:
+		return float64(x)
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// toNumber returns v as an int64 or float64, parsing numeric strings, or
+// nil if v is not a number. Strings such as "NaN" and "Inf" are not
+// numeric: they stay strings.
+func toNumber(v interface{}) interface{} {
+	switch x := normalize(v).(type) {
+	case int64, float64:
+		return x
+	case string:
+		s := strings.TrimSpace(x)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	}
+	return nil
+}
+
+func toFloat(n interface{}) float64 {
+	if i, ok := n.(int64); ok {
+		return float64(i)
+	}
+	f, _ := n.(float64)
+	return f
+}
+
+func toString(v interface{}) string {
+	switch x := normalize(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// Truthy reports whether v counts as true in a condition: null, false, zero,
+// the empty string and "false" do not.
+func Truthy(v interface{}) bool {
+	switch x := normalize(v).(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case int64:
+		return x != 0
+	case float64:
+		return x != 0
+	case string:
+		if b, err := strconv.ParseBool(x); err == nil {
+			return b
+		}
+		return x != ""
+	}
+	return true
+}
+
+// Equal compares two values the way the == operator does: numerically when
+// both are numbers or numeric strings, so the CSV string "15" equals the
+// JSON number 15. Null only equals null, and NaN equals nothing.
+func Equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// compare orders two non-null values: numerically if both are numeric,
+// false before true for booleans, and as strings otherwise. NaN is not
+// ordered.
+func compare(a, b interface{}) (int, bool) {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if an, bn := toNumber(a), toNumber(b); an != nil && bn != nil {
+		ai, aInt := an.(int64)
+		bi, bInt := bn.(int64)
+		if aInt && bInt {
+			return cmp(ai < bi, ai > bi), true
+		}
+		af, bf := toFloat(an), toFloat(bn)
+		if math.IsNaN(af) || math.IsNaN(bf) {
+			return 0, false
+		}
+		return cmp(af < bf, af > bf), true
+	}
+	ab, aBool := a.(bool)
+	bb, bBool := b.(bool)
+	if aBool && bBool {
+		return cmp(!ab && bb, ab && !bb), true
+	}
+	return strings.Compare(toString(a), toString(b)), true
+}
+
+func cmp(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,237 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"goxstream/internal/engine"
+	"goxstream/internal/model"
+)
+
+// State is the lifecycle state of a submitted job.
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Terminal reports whether the job can no longer change state.
+func (s State) Terminal() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+var (
+	ErrNotFound        = errors.New("job not found")
+	ErrAlreadyFinished = errors.New("job already finished")
+)
+
+// RunFunc runs a pipeline until it completes or ctx is cancelled. Its error
+// wraps context.Canceled if and only if ctx was cancelled before the
+// pipeline finished.
+type RunFunc func(ctx context.Context, spec model.PipelineSpec, stats *engine.Stats) (engine.RunStats, error)
+
+// Job is a single pipeline submission tracked by the Manager.
+type Job struct {
+	id   string
+	seq  int
+	spec model.PipelineSpec
+
+	mu          sync.Mutex
+	state       State
+	submittedAt time.Time
+	startedAt   time.Time
+	endedAt     time.Time
+	err         error
+	cancel      context.CancelFunc
+	done        chan struct{}
+	stats       engine.Stats
+}
+
+// Status is the JSON view of a job returned by the REST API.
+type Status struct {
+	ID          string     `json:"id"`
+	State       State      `json:"state"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RecordsIn   int64      `json:"records_in"`
+	RecordsOut  int64      `json:"records_out"`
+	// Edges reports queue depth, time blocked and drops per buffered edge.
+	Edges []engine.EdgeStat `json:"edges,omitempty"`
+	// Operators reports the counters operators keep, e.g. duplicates dropped.
+	Operators []engine.OperatorStat `json:"operators,omitempty"`
+	// Sinks reports the counters sinks keep, e.g. values a file sink dropped.
+	Sinks []engine.OperatorStat `json:"sinks,omitempty"`
+}
+
+func (j *Job) ID() string { return j.id }
+
+// Done is closed once the job has reached a terminal state.
+func (j *Job) Done() <-chan struct{} { return j.done }
+
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := Status{
+		ID:          j.id,
+		State:       j.state,
+		SubmittedAt: j.submittedAt,
+		RecordsIn:   j.stats.RecordsIn.Load(),
+		RecordsOut:  j.stats.RecordsOut.Load(),
+		Edges:       j.stats.Edges(),
+		Operators:   j.stats.Operators(),
+		Sinks:       j.stats.Sinks(),
+	}
+	if !j.startedAt.IsZero() {
+		t := j.startedAt
+		st.StartedAt = &t
+	}
+	if !j.endedAt.IsZero() {
+		t := j.endedAt
+		st.EndedAt = &t
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// Manager is an in-memory registry of submitted jobs.
+type Manager struct {
+	run RunFunc
+
+	mu     sync.RWMutex
+	jobs   map[string]*Job
+	nextID int
+}
+
+func NewManager(run RunFunc) *Manager {
+	return &Manager{run: run, jobs: make(map[string]*Job)}
+}
+
+// Submit registers a new job and starts it in the background.
+func (m *Manager) Submit(spec model.PipelineSpec) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m.mu.Lock()
+	m.nextID++
+	j := &Job{
+		id:          fmt.Sprintf("job-%d", m.nextID),
+		seq:         m.nextID,
+		spec:        spec,
+		state:       StatePending,
+		submittedAt: time.Now(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	go m.execute(ctx, j)
+	return j
+}
+
+func (m *Manager) execute(ctx context.Context, j *Job) {
+	defer close(j.done)
+	defer j.cancel()
+
+	j.mu.Lock()
+	if j.state != StatePending {
+		// Cancelled before it got a chance to start.
+		j.mu.Unlock()
+		return
+	}
+	j.state = StateRunning
+	j.startedAt = time.Now()
+	j.mu.Unlock()
+
+	run, err := m.run(ctx, j.spec, &j.stats)
+
+	// run's error alone decides the outcome: a cancel that arrives once the
+	// pipeline has finished changes nothing.
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endedAt = run.FinishedAt
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.state = StateCancelled
+		if err != context.Canceled {
+			j.err = err
+		}
+	case err != nil:
+		j.state = StateFailed
+		j.err = err
+	default:
+		j.state = StateSucceeded
+	}
+}
+
+// Get returns the job with the given id.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return j, nil
+}
+
+// List returns all known jobs, oldest first.
+func (m *Manager) List() []*Job {
+	m.mu.RLock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.RUnlock()
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].seq < jobs[b].seq
+	})
+	return jobs
+}
+
+// Cancel stops a pending or running job.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	j, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Terminal() {
+		return j, ErrAlreadyFinished
+	}
+	if j.state == StatePending {
+		j.state = StateCancelled
+		j.endedAt = time.Now()
+	}
+	j.cancel()
+	return j, nil
+}
+
+// Shutdown cancels every job that is still pending or running and waits for
+// them to finish draining, or for ctx to expire.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	jobs := m.List()
+	for _, j := range jobs {
+		m.Cancel(j.id)
+	}
+	for _, j := range jobs {
+		select {
+		case <-j.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"goxstream/internal/engine"
+	"goxstream/internal/model"
+)
+
+func TestFinalState(t *testing.T) {
+	failure := errors.New("sink error")
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, m *Manager, id string) error
+		want State
+		err  string
+	}{
+		{
+			name: "succeeded",
+			run:  func(context.Context, *Manager, string) error { return nil },
+			want: StateSucceeded,
+		},
+		{
+			name: "failed",
+			run:  func(context.Context, *Manager, string) error { return failure },
+			want: StateFailed,
+			err:  "sink error",
+		},
+		{
+			name: "cancelled while running",
+			run: func(ctx context.Context, m *Manager, id string) error {
+				m.Cancel(id)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			want: StateCancelled,
+		},
+		{
+			name: "cancelled with a stage failure",
+			run: func(ctx context.Context, m *Manager, id string) error {
+				m.Cancel(id)
+				return errors.Join(failure, ctx.Err())
+			},
+			want: StateCancelled,
+			err:  "sink error\ncontext canceled",
+		},
+		{
+			// The pipeline finished before the cancel took effect.
+			name: "cancelled after completion",
+			run: func(ctx context.Context, m *Manager, id string) error {
+				m.Cancel(id)
+				return nil
+			},
+			want: StateSucceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m *Manager
+			started := make(chan string, 1)
+			m = NewManager(func(ctx context.Context, spec model.PipelineSpec, stats *engine.Stats) (engine.RunStats, error) {
+				err := tt.run(ctx, m, <-started)
+				return engine.RunStats{FinishedAt: time.Now()}, err
+			})
+			j := m.Submit(model.PipelineSpec{})
+			started <- j.ID()
+			select {
+			case <-j.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("job did not finish")
+			}
+			st := j.Status()
+			if st.State != tt.want || st.Error != tt.err {
+				t.Errorf("state = %s, error = %q, want %s, %q", st.State, st.Error, tt.want, tt.err)
+			}
+			if _, err := m.Cancel(j.ID()); err != ErrAlreadyFinished {
+				t.Errorf("Cancel after the end = %v, want ErrAlreadyFinished", err)
+			}
+		})
+	}
+}
@@ -2,8 +2,53 @@ package model
 
 import "time"
 
+// Kind distinguishes data records from control markers that travel in-band
+// with them.
+type Kind int
+
+const (
+    KindRecord  Kind = iota // a regular data record
+    KindBarrier             // a checkpoint barrier; Checkpoint holds its ID
+    KindWatermark           // an event-time watermark; Timestamp holds it
+)
+
 // Event represents a single record flowing through the pipeline.
 type Event struct {
-    Data      map[string]interface{}
-    Timestamp time.Time
+    Data       map[string]interface{} `json:"data"`
+    Timestamp  time.Time              `json:"timestamp"`
+    Kind       Kind                   `json:"kind,omitempty"`
+    Checkpoint int64                  `json:"checkpoint,omitempty"` // barrier ID, only set when Kind == KindBarrier
+    Offset     *Offset                `json:"offset,omitempty"`     // source position of the record, if the source tracks one
+    // Output names the side output an operator emitted the record on, e.g.
+    // "late"; empty for the main output. Side output records skip the rest
+    // of the operator chain and only follow edges for that output.
+    Output string `json:"output,omitempty"`
+    // Input is the ID of the node a record arrived from. It is only set on
+    // the inputs of a join node, which needs to tell its sides apart.
+    Input string `json:"input,omitempty"`
+}
+
+// IsRecord reports whether e carries data rather than a control marker.
+func (e Event) IsRecord() bool { return e.Kind == KindRecord }
+
+// Offset is a position within one source partition, e.g. a line number in a
+// file or a Kafka offset within a topic partition.
+type Offset struct {
+    Key   string `json:"key"`
+    Value int64  `json:"value"`
+}
+
+// Offsets maps each source partition key to the last position processed.
+type Offsets map[string]int64
+
+// Clone returns a copy of e whose Data map can be modified independently.
+func (e Event) Clone() Event {
+    if e.Data != nil {
+        data := make(map[string]interface{}, len(e.Data))
+        for k, v := range e.Data {
+            data[k] = v
+        }
+        e.Data = data
+    }
+    return e
 }
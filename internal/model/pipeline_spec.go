@@ -1,9 +1,69 @@
 package model
 
 type PipelineSpec struct {
-    Source    SourceSpec      `json:"source"`
-    Operators []OperatorSpec  `json:"operators"`
-    Sink      SinkSpec        `json:"sink"`
+    Source     SourceSpec      `json:"source"`
+    Operators  []OperatorSpec  `json:"operators"`
+    Sink       SinkSpec        `json:"sink"`
+    Checkpoint *CheckpointSpec `json:"checkpoint,omitempty"`
+    // Parallelism is the number of workers running the operators after the
+    // first key_by operator; each worker owns the keys hashed to it.
+    Parallelism int `json:"parallelism,omitempty"`
+    // Buffer is the default bounded buffer between stages.
+    Buffer *BufferSpec `json:"buffer,omitempty"`
+    // Watermark generates event-time watermarks at every source; time-based
+    // operators then fire from them instead of tracking their own.
+    Watermark *WatermarkSpec `json:"watermark,omitempty"`
+
+    // Nodes and Edges describe a DAG pipeline and replace Source, Operators
+    // and Sink when set.
+    Nodes []NodeSpec `json:"nodes,omitempty"`
+    Edges []EdgeSpec `json:"edges,omitempty"`
+}
+
+// IsGraph reports whether the spec is a DAG rather than a linear pipeline.
+func (s PipelineSpec) IsGraph() bool { return len(s.Nodes) > 0 }
+
+// NodeSpec is one vertex of a DAG pipeline. Type is "source", "sink",
+// "union" or an operator type; for sources and sinks Params holds the same
+// config as a linear pipeline's source or sink, e.g.
+// { "id": "in", "type": "source", "params": { "type": "file", "path": "input.csv" } }.
+type NodeSpec struct {
+    ID     string                 `json:"id"`
+    Type   string                 `json:"type"`
+    Params map[string]interface{} `json:"params"`
+}
+
+// EdgeSpec connects two nodes of a DAG pipeline by ID.
+type EdgeSpec struct {
+    From   string      `json:"from"`
+    To     string      `json:"to"`
+    Buffer *BufferSpec `json:"buffer,omitempty"` // overrides the pipeline default
+    // Output selects a side output of From instead of its main output,
+    // e.g. "late" for the late events of a watermark window.
+    Output string `json:"output,omitempty"`
+}
+
+// BufferSpec sizes the bounded buffer on an edge and sets what happens to a
+// record arriving when it is full: "block" (default) waits for the consumer,
+// "drop_oldest" discards the oldest buffered record and "drop_newest"
+// discards the arriving one, e.g. { "size": 1000, "policy": "drop_oldest" }.
+type BufferSpec struct {
+    Size   int    `json:"size"`
+    Policy string `json:"policy"`
+}
+
+// WatermarkSpec configures how each source derives its watermark:
+// "bounded" (default) trails the max event time by MaxOutOfOrderness, and
+// "punctuated" takes it from the RFC3339 time in Field of the records that
+// carry one. With IdleTimeout set, a source that has produced no records for
+// that long advances its watermark to its max event time and then with the
+// wall clock, e.g.
+// { "strategy": "bounded", "max_out_of_orderness": "5s", "idle_timeout": "1m" }.
+type WatermarkSpec struct {
+    Strategy          string `json:"strategy"`
+    MaxOutOfOrderness string `json:"max_out_of_orderness"`
+    Field             string `json:"field"`
+    IdleTimeout       string `json:"idle_timeout"`
 }
 
 type SourceSpec struct {
@@ -22,3 +82,10 @@ type SinkSpec struct {
     Path string `json:"path"`
 	Raw map[string]interface{} `json:"-"`
 }
+
+// CheckpointSpec enables periodic checkpoints of operator state and source
+// offsets, e.g. { "dir": "checkpoints/orders", "interval": "10s" }.
+type CheckpointSpec struct {
+    Dir      string `json:"dir"`
+    Interval string `json:"interval"`
+}
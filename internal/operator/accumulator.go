@@ -0,0 +1,119 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"goxstream/internal/model"
+)
+
+// Accumulator is the incremental state of a window's inner aggregation.
+// Windows add events to it as they arrive instead of buffering them, and
+// sliding windows merge the accumulators of their panes. Accumulators are
+// checkpointed as JSON.
+type Accumulator interface {
+	Add(event model.Event)
+	// Merge folds other, which saw later events, into the accumulator
+	// without modifying other.
+	Merge(other Accumulator)
+	Result() []model.Event
+}
+
+// Aggregator is implemented by inner operators that aggregate incrementally.
+type Aggregator interface {
+	CreateAccumulator() Accumulator
+}
+
+// aggregatorFor returns the incremental aggregation of a window's inner
+// operator. Inner operators that only implement ProcessBatch still buffer
+// their window's events.
+func aggregatorFor(inner Operator) Aggregator {
+	if agg, ok := inner.(Aggregator); ok {
+		return agg
+	}
+	if batcher, ok := inner.(interface {
+		ProcessBatch([]model.Event) []model.Event
+	}); ok {
+		return batchAggregator{batcher.ProcessBatch}
+	}
+	return nil
+}
+
+// checkAggregates returns an error if inner cannot be a window's inner
+// operator. Window factories call it before building a window, whose panes
+// need an aggregator.
+func checkAggregates(inner Operator) error {
+	if aggregatorFor(inner) == nil {
+		return fmt.Errorf("window inner operator %s does not aggregate", inner.Name())
+	}
+	return nil
+}
+
+type batchAggregator struct {
+	process func([]model.Event) []model.Event
+}
+
+func (b batchAggregator) CreateAccumulator() Accumulator {
+	return &batchAccumulator{process: b.process}
+}
+
+type batchAccumulator struct {
+	process func([]model.Event) []model.Event
+	Events  []model.Event `json:"events"`
+}
+
+func (b *batchAccumulator) Add(e model.Event) { b.Events = append(b.Events, e) }
+func (b *batchAccumulator) Merge(other Accumulator) {
+	b.Events = append(b.Events, other.(*batchAccumulator).Events...)
+}
+func (b *batchAccumulator) Result() []model.Event { return b.process(b.Events) }
+
+// pane is the accumulator of one window, or one pane of a sliding window,
+// together with the number of events added to it.
+type pane struct {
+	acc Accumulator
+	n   int
+}
+
+func newPane(agg Aggregator) *pane { return &pane{acc: agg.CreateAccumulator()} }
+
+func (p *pane) add(e model.Event) {
+	p.acc.Add(e)
+	p.n++
+}
+
+type paneState struct {
+	N   int             `json:"n"`
+	Acc json.RawMessage `json:"acc"`
+}
+
+func (p *pane) state() (paneState, error) {
+	data, err := json.Marshal(p.acc)
+	return paneState{N: p.n, Acc: data}, err
+}
+
+func restorePane(agg Aggregator, st paneState) (*pane, error) {
+	p := newPane(agg)
+	p.n = st.N
+	if err := json.Unmarshal(st.Acc, p.acc); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// mergePanes combines panes into a fresh accumulator and returns it with
+// the total event count.
+func mergePanes(agg Aggregator, panes []*pane) *pane {
+	out := newPane(agg)
+	for _, p := range panes {
+		out.acc.Merge(p.acc)
+		out.n += p.n
+	}
+	return out
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
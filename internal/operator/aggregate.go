@@ -0,0 +1,312 @@
+package operator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregation is one aggregate computed by reduce, e.g.
+// { "agg": "percentile", "field": "score", "p": 95, "as": "p95" }.
+type Aggregation struct {
+	Agg   string  // count, sum, min, max, avg, count_distinct, first, last, stddev, percentile
+	Field string  // value field; optional for count
+	As    string  // output column
+	P     float64 // percentile rank, 0-100
+}
+
+// aggNeedsField lists the aggregations that read a value field, and whether
+// they need one.
+var aggNeedsField = map[string]bool{
+	"count":          false,
+	"sum":            true,
+	"min":            true,
+	"max":            true,
+	"avg":            true,
+	"count_distinct": true,
+	"first":          true,
+	"last":           true,
+	"stddev":         true,
+	"percentile":     true,
+}
+
+// parseAggregation validates one aggregation spec and fills in its output
+// column name.
+func parseAggregation(spec map[string]interface{}) (Aggregation, error) {
+	a := Aggregation{}
+	a.Agg, _ = spec["agg"].(string)
+	a.Field, _ = spec["field"].(string)
+	a.As, _ = spec["as"].(string)
+	needsField, ok := aggNeedsField[a.Agg]
+	if !ok {
+		return a, fmt.Errorf("unknown aggregation: %q", a.Agg)
+	}
+	if needsField && a.Field == "" {
+		return a, fmt.Errorf("aggregation %s expects field", a.Agg)
+	}
+	if a.Agg == "percentile" {
+		p, ok := toFloat(spec["p"])
+		if !ok || p < 0 || p > 100 {
+			return a, fmt.Errorf("percentile expects p between 0 and 100")
+		}
+		a.P = p
+	}
+	if a.As == "" {
+		switch {
+		case a.Field == "":
+			a.As = a.Agg
+		case a.Agg == "percentile":
+			a.As = fmt.Sprintf("p%s_%s", strconv.FormatFloat(a.P, 'f', -1, 64), a.Field)
+		default:
+			a.As = a.Agg + "_" + a.Field
+		}
+	}
+	return a, nil
+}
+
+// accumulator incrementally computes one aggregation over the values added
+// to it. Merging combines two partial results, the argument having seen the
+// later values.
+type accumulator interface {
+	add(v interface{})
+	merge(other accumulator)
+	result() interface{}
+}
+
+// create returns an empty accumulator for the aggregation.
+func (a Aggregation) create() accumulator {
+	switch a.Agg {
+	case "count":
+		return &countAcc{}
+	case "sum":
+		return &sumAcc{}
+	case "min":
+		return &extremeAcc{sign: -1}
+	case "max":
+		return &extremeAcc{sign: 1}
+	case "avg":
+		return &avgAcc{}
+	case "count_distinct":
+		return &distinctAcc{Seen: make(map[string]bool)}
+	case "first":
+		return &firstAcc{}
+	case "last":
+		return &lastAcc{}
+	case "stddev":
+		return &stddevAcc{}
+	case "percentile":
+		return &percentileAcc{P: a.P}
+	}
+	panic("unknown aggregation: " + a.Agg)
+}
+
+// count counts events, or the non-null values of a field if one is given.
+type countAcc struct {
+	N int64 `json:"n"`
+}
+
+func (c *countAcc) add(interface{})         { c.N++ }
+func (c *countAcc) merge(other accumulator) { c.N += other.(*countAcc).N }
+func (c *countAcc) result() interface{}     { return c.N }
+
+type sumAcc struct {
+	Sum float64 `json:"sum"`
+}
+
+func (s *sumAcc) add(v interface{}) {
+	if f, ok := toFloat(v); ok {
+		s.Sum += f
+	}
+}
+func (s *sumAcc) merge(other accumulator) { s.Sum += other.(*sumAcc).Sum }
+func (s *sumAcc) result() interface{}     { return s.Sum }
+
+// extremeAcc keeps the smallest (sign -1) or largest (sign 1) value,
+// comparing numerically when both values are numbers.
+type extremeAcc struct {
+	sign int
+	V    interface{} `json:"v"`
+}
+
+func (m *extremeAcc) add(v interface{}) {
+	if m.V == nil || compareValues(v, m.V)*m.sign > 0 {
+		m.V = v
+	}
+}
+func (m *extremeAcc) merge(other accumulator) {
+	if o := other.(*extremeAcc); o.V != nil {
+		m.add(o.V)
+	}
+}
+func (m *extremeAcc) result() interface{} {
+	if f, ok := toFloat(m.V); ok {
+		return f
+	}
+	return m.V
+}
+
+type avgAcc struct {
+	Sum float64 `json:"sum"`
+	N   int64   `json:"n"`
+}
+
+func (a *avgAcc) add(v interface{}) {
+	if f, ok := toFloat(v); ok {
+		a.Sum += f
+		a.N++
+	}
+}
+func (a *avgAcc) merge(other accumulator) {
+	o := other.(*avgAcc)
+	a.Sum += o.Sum
+	a.N += o.N
+}
+func (a *avgAcc) result() interface{} {
+	if a.N == 0 {
+		return nil
+	}
+	return a.Sum / float64(a.N)
+}
+
+type distinctAcc struct {
+	Seen map[string]bool `json:"seen"`
+}
+
+func (d *distinctAcc) add(v interface{}) { d.Seen[fmt.Sprintf("%v", v)] = true }
+func (d *distinctAcc) merge(other accumulator) {
+	for k := range other.(*distinctAcc).Seen {
+		d.Seen[k] = true
+	}
+}
+func (d *distinctAcc) result() interface{} { return int64(len(d.Seen)) }
+
+type firstAcc struct {
+	V   interface{} `json:"v"`
+	Set bool        `json:"set"`
+}
+
+func (f *firstAcc) add(v interface{}) {
+	if !f.Set {
+		f.V, f.Set = v, true
+	}
+}
+func (f *firstAcc) merge(other accumulator) {
+	if o := other.(*firstAcc); o.Set {
+		f.add(o.V)
+	}
+}
+func (f *firstAcc) result() interface{} { return f.V }
+
+type lastAcc struct {
+	V interface{} `json:"v"`
+}
+
+func (l *lastAcc) add(v interface{}) { l.V = v }
+func (l *lastAcc) merge(other accumulator) {
+	if o := other.(*lastAcc); o.V != nil {
+		l.V = o.V
+	}
+}
+func (l *lastAcc) result() interface{} { return l.V }
+
+// stddevAcc computes the sample standard deviation with Welford's online
+// algorithm; partial results merge with Chan's parallel formula.
+type stddevAcc struct {
+	N    int64   `json:"n"`
+	Mean float64 `json:"mean"`
+	M2   float64 `json:"m2"`
+}
+
+func (s *stddevAcc) add(v interface{}) {
+	f, ok := toFloat(v)
+	if !ok {
+		return
+	}
+	s.N++
+	d := f - s.Mean
+	s.Mean += d / float64(s.N)
+	s.M2 += d * (f - s.Mean)
+}
+func (s *stddevAcc) merge(other accumulator) {
+	o := other.(*stddevAcc)
+	if o.N == 0 {
+		return
+	}
+	n := s.N + o.N
+	d := o.Mean - s.Mean
+	s.M2 += o.M2 + d*d*float64(s.N)*float64(o.N)/float64(n)
+	s.Mean += d * float64(o.N) / float64(n)
+	s.N = n
+}
+func (s *stddevAcc) result() interface{} {
+	if s.N < 2 {
+		return nil
+	}
+	return math.Sqrt(s.M2 / float64(s.N-1))
+}
+
+// percentileAcc keeps every value and interpolates linearly between the
+// closest ranks.
+type percentileAcc struct {
+	P      float64   `json:"p"`
+	Values []float64 `json:"values"`
+}
+
+func (p *percentileAcc) add(v interface{}) {
+	if f, ok := toFloat(v); ok {
+		p.Values = append(p.Values, f)
+	}
+}
+func (p *percentileAcc) merge(other accumulator) {
+	p.Values = append(p.Values, other.(*percentileAcc).Values...)
+}
+func (p *percentileAcc) result() interface{} {
+	if len(p.Values) == 0 {
+		return nil
+	}
+	vs := append([]float64(nil), p.Values...)
+	sort.Float64s(vs)
+	rank := p.P / 100 * float64(len(vs)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return vs[lo] + (vs[hi]-vs[lo])*(rank-float64(lo))
+}
+
+// toFloat converts numbers and numeric strings (as read from CSV) to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareValues orders two values numerically if both are numbers, and as
+// strings otherwise.
+func compareValues(a, b interface{}) int {
+	af, aOk := toFloat(a)
+	bf, bOk := toFloat(b)
+	if aOk && bOk {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
@@ -0,0 +1,128 @@
+package operator
+
+import (
+    "container/list"
+    "encoding/json"
+    "fmt"
+    "goxstream/internal/model"
+    "hash/fnv"
+    "sync/atomic"
+    "time"
+)
+
+// DedupOperator drops events whose key was already seen within ttl. The key
+// is the values of the key fields or, without any, a hash of the whole
+// record. A duplicate counts as a sighting, so a key stays suppressed while
+// it keeps recurring within ttl. Keys are forgotten once ttl has passed
+// since they were last seen, and the least recently seen first whenever
+// more than maxKeys are remembered.
+type DedupOperator struct {
+    name    string
+    fields  []string
+    ttl     time.Duration // 0: keys do not expire
+    maxKeys int           // 0: no limit
+    seen    map[string]*list.Element
+    order   *list.List // of *dedupEntry, most recently seen first
+    dropped atomic.Int64
+}
+
+type dedupEntry struct {
+    Key  string    `json:"key"`
+    Seen time.Time `json:"seen"`
+}
+
+func NewDedupOperator(name string, fields []string, ttl time.Duration, maxKeys int) *DedupOperator {
+    return &DedupOperator{
+        name:    name,
+        fields:  fields,
+        ttl:     ttl,
+        maxKeys: maxKeys,
+        seen:    make(map[string]*list.Element),
+        order:   list.New(),
+    }
+}
+
+func (op *DedupOperator) Name() string { return op.name }
+
+func (op *DedupOperator) key(e model.Event) string {
+    if len(op.fields) > 0 {
+        key := make([]interface{}, len(op.fields))
+        for i, f := range op.fields {
+            key[i] = e.Data[f]
+        }
+        return groupKeyOf(key)
+    }
+    // encoding/json sorts map keys, so equal records hash alike.
+    data, _ := json.Marshal(e.Data)
+    h := fnv.New64a()
+    h.Write(data)
+    return fmt.Sprintf("%x", h.Sum64())
+}
+
+func (op *DedupOperator) Process(event model.Event) []model.Event {
+    now := time.Now()
+    op.expire(now)
+    k := op.key(event)
+    if el, ok := op.seen[k]; ok {
+        el.Value.(*dedupEntry).Seen = now
+        op.order.MoveToFront(el)
+        op.dropped.Add(1)
+        return []model.Event{}
+    }
+    op.remember(&dedupEntry{Key: k, Seen: now})
+    return []model.Event{event}
+}
+
+func (op *DedupOperator) remember(e *dedupEntry) {
+    op.seen[e.Key] = op.order.PushFront(e)
+    if op.maxKeys > 0 && op.order.Len() > op.maxKeys {
+        op.forget(op.order.Back())
+    }
+}
+
+func (op *DedupOperator) forget(el *list.Element) {
+    op.order.Remove(el)
+    delete(op.seen, el.Value.(*dedupEntry).Key)
+}
+
+// expire forgets the keys last seen more than ttl before now.
+func (op *DedupOperator) expire(now time.Time) {
+    if op.ttl <= 0 {
+        return
+    }
+    for el := op.order.Back(); el != nil && now.Sub(el.Value.(*dedupEntry).Seen) > op.ttl; el = op.order.Back() {
+        op.forget(el)
+    }
+}
+
+// Counters reports the number of duplicates dropped.
+func (op *DedupOperator) Counters() map[string]int64 {
+    return map[string]int64{"duplicates": op.dropped.Load()}
+}
+
+type dedupState struct {
+    Keys    []*dedupEntry `json:"keys"` // least recently seen first
+    Dropped int64         `json:"dropped"`
+}
+
+func (op *DedupOperator) Snapshot() ([]byte, error) {
+    st := dedupState{Keys: make([]*dedupEntry, 0, op.order.Len()), Dropped: op.dropped.Load()}
+    for el := op.order.Back(); el != nil; el = el.Prev() {
+        st.Keys = append(st.Keys, el.Value.(*dedupEntry))
+    }
+    return json.Marshal(st)
+}
+
+func (op *DedupOperator) Restore(data []byte) error {
+    var st dedupState
+    if err := json.Unmarshal(data, &st); err != nil {
+        return err
+    }
+    op.seen = make(map[string]*list.Element, len(st.Keys))
+    op.order = list.New()
+    for _, e := range st.Keys {
+        op.remember(e)
+    }
+    op.dropped.Store(st.Dropped)
+    return nil
+}
@@ -0,0 +1,287 @@
+package operator
+
+import (
+    "container/heap"
+    "encoding/json"
+    "fmt"
+    "goxstream/internal/model"
+    "sort"
+    "sync/atomic"
+    "time"
+)
+
+// JoinOperator joins the records of two input nodes on a key. A left and a
+// right record match when their event times are at most interval apart or,
+// with window set instead, fall in the same tumbling window. Each record is
+// buffered until the watermark passes the last time it could still match;
+// in a left join, unmatched left records are then emitted alone, and in a
+// full join unmatched records of either side. Until the pipeline provides a
+// watermark, the join uses the smaller of the two sides' max event times.
+// A record missing a key field matches nothing: it is emitted alone right
+// away if its side is outer, and dropped otherwise. A record too late to
+// match is tagged with late=true, or emitted on the LateOutput side output if
+// lateOutput is "side".
+type JoinOperator struct {
+    name            string
+    inputs          [2]string   // left and right node IDs
+    keys            [2][]string // key fields of each side
+    joinType        string      // inner, left or full
+    lateOutput      string
+    interval        time.Duration
+    window          time.Duration
+    buffered        [2]map[string][]*joinEntry // records of each side by key
+    expiry          joinHeap                   // every buffered record, soonest to expire first
+    maxEventTime    [2]time.Time
+    watermark       time.Time
+    streamWatermark bool // use the pipeline's watermark
+    unkeyed         atomic.Int64 // records without a key
+}
+
+type joinEntry struct {
+    Side    int         `json:"side"`
+    Key     string      `json:"key"`
+    Event   model.Event `json:"event"`
+    Matched bool        `json:"matched"`
+    expires time.Time
+}
+
+func NewJoinOperator(name, left, right string, leftKey, rightKey []string, joinType, lateOutput string, interval, window time.Duration) *JoinOperator {
+    return &JoinOperator{
+        name:       name,
+        inputs:     [2]string{left, right},
+        keys:       [2][]string{leftKey, rightKey},
+        joinType:   joinType,
+        lateOutput: lateOutput,
+        interval:   interval,
+        window:     window,
+        buffered:   [2]map[string][]*joinEntry{make(map[string][]*joinEntry), make(map[string][]*joinEntry)},
+    }
+}
+
+func (op *JoinOperator) Name() string { return op.name }
+
+// expires is the last event time a record can match at.
+func (op *JoinOperator) expires(e model.Event) time.Time {
+    if op.window > 0 {
+        return windowTime(e.Timestamp.Truncate(op.window).Add(op.window))
+    }
+    return e.Timestamp.Add(op.interval)
+}
+
+func (op *JoinOperator) matches(a, b model.Event) bool {
+    if op.window > 0 {
+        return a.Timestamp.Truncate(op.window).Equal(b.Timestamp.Truncate(op.window))
+    }
+    d := a.Timestamp.Sub(b.Timestamp)
+    return d <= op.interval && -d <= op.interval
+}
+
+// key returns the join key of a record, and false if a key field is
+// missing or null.
+func (op *JoinOperator) key(side int, e model.Event) (string, bool) {
+    key := make([]interface{}, len(op.keys[side]))
+    for i, f := range op.keys[side] {
+        if key[i] = e.Data[f]; key[i] == nil {
+            return "", false
+        }
+    }
+    return groupKeyOf(key), true
+}
+
+func (op *JoinOperator) Process(event model.Event) []model.Event {
+    side := -1
+    for i, input := range op.inputs {
+        if event.Input == input {
+            side = i
+        }
+    }
+    if side < 0 {
+        return []model.Event{}
+    }
+    event.Input = ""
+    entry := &joinEntry{Side: side, Event: event, expires: op.expires(event)}
+    if entry.expires.Before(op.watermark) {
+        return []model.Event{lateEvent(event, op.lateOutput)}
+    }
+    var keyed bool
+    if entry.Key, keyed = op.key(side, event); !keyed {
+        op.unkeyed.Add(1)
+        if op.outer(side) {
+            return []model.Event{event}
+        }
+        return []model.Event{}
+    }
+
+    out := []model.Event{}
+    for _, other := range op.buffered[1-side][entry.Key] {
+        if op.matches(event, other.Event) {
+            if side == 0 {
+                out = append(out, op.joined(event, other.Event))
+            } else {
+                out = append(out, op.joined(other.Event, event))
+            }
+            entry.Matched, other.Matched = true, true
+        }
+    }
+    op.add(entry)
+
+    if event.Timestamp.After(op.maxEventTime[side]) {
+        op.maxEventTime[side] = event.Timestamp
+    }
+    if !op.streamWatermark {
+        wm := op.maxEventTime[0]
+        if op.maxEventTime[1].Before(wm) {
+            wm = op.maxEventTime[1]
+        }
+        if wm.After(op.watermark) {
+            op.watermark = wm
+        }
+    }
+    return append(out, op.evict()...)
+}
+
+func (op *JoinOperator) add(entry *joinEntry) {
+    op.buffered[entry.Side][entry.Key] = append(op.buffered[entry.Side][entry.Key], entry)
+    heap.Push(&op.expiry, entry)
+}
+
+// joined combines a matching pair. A right field whose name the left record
+// already uses is renamed <right>_<field>, unless both hold the same join key.
+func (op *JoinOperator) joined(l, r model.Event) model.Event {
+    data := make(map[string]interface{}, len(l.Data)+len(r.Data))
+    for k, v := range l.Data {
+        data[k] = v
+    }
+    for k, v := range r.Data {
+        if _, taken := data[k]; taken {
+            if op.sharedKey(k) {
+                continue
+            }
+            k = op.inputs[1] + "_" + k
+        }
+        data[k] = v
+    }
+    ts := l.Timestamp
+    if r.Timestamp.After(ts) {
+        ts = r.Timestamp
+    }
+    return model.Event{Data: data, Timestamp: ts}
+}
+
+func (op *JoinOperator) sharedKey(field string) bool {
+    for i, k := range op.keys[0] {
+        if k == field && op.keys[1][i] == field {
+            return true
+        }
+    }
+    return false
+}
+
+// outer reports whether unmatched records of side are emitted.
+func (op *JoinOperator) outer(side int) bool {
+    return op.joinType == "full" || (op.joinType == "left" && side == 0)
+}
+
+// evict drops the records that can no longer match and emits the unmatched
+// ones of outer sides.
+func (op *JoinOperator) evict() []model.Event {
+    out := []model.Event{}
+    for op.expiry.Len() > 0 && op.expiry[0].expires.Before(op.watermark) {
+        e := heap.Pop(&op.expiry).(*joinEntry)
+        entries := op.buffered[e.Side][e.Key]
+        for i, b := range entries {
+            if b == e {
+                entries = append(entries[:i], entries[i+1:]...)
+                break
+            }
+        }
+        if len(entries) == 0 {
+            delete(op.buffered[e.Side], e.Key)
+        } else {
+            op.buffered[e.Side][e.Key] = entries
+        }
+        if !e.Matched && op.outer(e.Side) {
+            out = append(out, e.Event)
+        }
+    }
+    return out
+}
+
+func (op *JoinOperator) OnWatermark(wm time.Time) []model.Event {
+    op.streamWatermark = true
+    if wm.After(op.watermark) {
+        op.watermark = wm
+    }
+    return op.evict()
+}
+
+// Flush emits the unmatched records of outer sides still buffered.
+func (op *JoinOperator) Flush() []model.Event {
+    out := []model.Event{}
+    for op.expiry.Len() > 0 {
+        e := heap.Pop(&op.expiry).(*joinEntry)
+        if !e.Matched && op.outer(e.Side) {
+            out = append(out, e.Event)
+        }
+    }
+    op.buffered = [2]map[string][]*joinEntry{make(map[string][]*joinEntry), make(map[string][]*joinEntry)}
+    return out
+}
+
+// Counters reports the number of records that had no key.
+func (op *JoinOperator) Counters() map[string]int64 {
+    return map[string]int64{"unkeyed": op.unkeyed.Load()}
+}
+
+type joinState struct {
+    Entries         []*joinEntry `json:"entries"`
+    MaxEventTime    [2]time.Time `json:"max_event_time"`
+    Watermark       time.Time    `json:"watermark"`
+    StreamWatermark bool         `json:"stream_watermark"`
+    Unkeyed         int64        `json:"unkeyed"`
+}
+
+func (op *JoinOperator) Snapshot() ([]byte, error) {
+    entries := append([]*joinEntry(nil), op.expiry...)
+    sort.Slice(entries, func(i, j int) bool { return entries[i].expires.Before(entries[j].expires) })
+    return json.Marshal(joinState{
+        Entries:         entries,
+        MaxEventTime:    op.maxEventTime,
+        Watermark:       op.watermark,
+        StreamWatermark: op.streamWatermark,
+        Unkeyed:         op.unkeyed.Load(),
+    })
+}
+
+func (op *JoinOperator) Restore(data []byte) error {
+    var st joinState
+    if err := json.Unmarshal(data, &st); err != nil {
+        return err
+    }
+    op.buffered = [2]map[string][]*joinEntry{make(map[string][]*joinEntry), make(map[string][]*joinEntry)}
+    op.expiry = nil
+    for _, e := range st.Entries {
+        if e.Side != 0 && e.Side != 1 {
+            return fmt.Errorf("join state has invalid side %d", e.Side)
+        }
+        e.expires = op.expires(e.Event)
+        op.add(e)
+    }
+    op.maxEventTime, op.watermark, op.streamWatermark = st.MaxEventTime, st.Watermark, st.StreamWatermark
+    op.unkeyed.Store(st.Unkeyed)
+    return nil
+}
+
+// joinHeap orders buffered records by expiry.
+type joinHeap []*joinEntry
+
+func (h joinHeap) Len() int            { return len(h) }
+func (h joinHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h joinHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *joinHeap) Push(x interface{}) { *h = append(*h, x.(*joinEntry)) }
+func (h *joinHeap) Pop() interface{} {
+    old := *h
+    e := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return e
+}
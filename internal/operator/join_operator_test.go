@@ -0,0 +1,79 @@
+package operator
+
+import (
+	"testing"
+
+	"goxstream/internal/model"
+)
+
+// joinInput is a record arriving at a join from input.
+func joinInput(input string, sec int, data map[string]interface{}) model.Event {
+	e := record(sec, data)
+	e.Input = input
+	return e
+}
+
+func buildJoin(t *testing.T, params map[string]interface{}) *JoinOperator {
+	t.Helper()
+	p := map[string]interface{}{"left": "l", "right": "r", "key": "id", "interval": "5s", "join": "left"}
+	for k, v := range params {
+		p[k] = v
+	}
+	return build(t, "join", p).(*JoinOperator)
+}
+
+func TestJoinUnkeyedRecords(t *testing.T) {
+	op := buildJoin(t, nil)
+	var out []model.Event
+	out = append(out, op.Process(joinInput("l", 0, map[string]interface{}{"v": "l1"}))...)
+	out = append(out, op.Process(joinInput("r", 0, map[string]interface{}{"v": "r1"}))...)
+	out = append(out, op.Process(joinInput("l", 1, map[string]interface{}{"id": nil, "v": "l2"}))...)
+	out = append(out, op.Process(joinInput("r", 1, map[string]interface{}{"id": nil, "v": "r2"}))...)
+	out = append(out, op.Flush()...)
+
+	// The left records come out alone, right away; the right ones match
+	// nothing and are dropped.
+	var got []string
+	for _, e := range out {
+		if _, joined := e.Data["r_v"]; joined {
+			t.Errorf("records without a key were joined: %v", e.Data)
+		}
+		got = append(got, e.Data["v"].(string))
+	}
+	if !equalStrings(got, []string{"l1", "l2"}) {
+		t.Errorf("output = %v, want [l1 l2]", got)
+	}
+	if n := op.Counters()["unkeyed"]; n != 4 {
+		t.Errorf("unkeyed = %d, want 4", n)
+	}
+}
+
+// A left record too late to match must not be lost: it comes out alone,
+// tagged late, or on the late output.
+func TestJoinLateRecords(t *testing.T) {
+	tests := []struct {
+		lateOutput string
+		output     string
+		tagged     bool
+	}{
+		{lateOutput: "tag", tagged: true},
+		{lateOutput: "side", output: LateOutput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lateOutput, func(t *testing.T) {
+			op := buildJoin(t, map[string]interface{}{"late_output": tt.lateOutput})
+			op.OnWatermark(at(60))
+			out := op.Process(joinInput("l", 0, map[string]interface{}{"id": "a", "v": "l1"}))
+			if len(out) != 1 {
+				t.Fatalf("output = %v, want the late record", out)
+			}
+			e := out[0]
+			if e.Data["v"] != "l1" || e.Output != tt.output || (e.Data["late"] == true) != tt.tagged {
+				t.Errorf("late record = %+v, want output %q, tagged %v", e, tt.output, tt.tagged)
+			}
+			if rest := op.Flush(); len(rest) != 0 {
+				t.Errorf("late record was also buffered: %v", rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package operator
+
+import (
+    "fmt"
+    "goxstream/internal/model"
+)
+
+// KeyByOperator marks where the chain is partitioned by key. It passes events
+// through unchanged; with parallelism > 1 the engine routes each event to the
+// worker that owns its key, so every operator after it sees all events for a
+// key in order.
+type KeyByOperator struct {
+    field string
+}
+
+func NewKeyByOperator(field string) *KeyByOperator {
+    return &KeyByOperator{field: field}
+}
+
+func (op *KeyByOperator) Name() string { return "key_by" }
+
+func (op *KeyByOperator) Process(event model.Event) []model.Event {
+    return []model.Event{event}
+}
+
+// Key returns the partitioning key of an event.
+func (op *KeyByOperator) Key(event model.Event) string {
+    return fmt.Sprintf("%v", event.Data[op.field])
+}
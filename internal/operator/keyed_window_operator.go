@@ -0,0 +1,173 @@
+package operator
+
+import (
+    "encoding/json"
+    "fmt"
+    "goxstream/internal/model"
+    "sort"
+    "time"
+)
+
+// KeyedWindowOperator runs a separate instance of a window operator for each
+// value of a key field, so window counts, time boundaries and watermarks are
+// tracked independently per key. A key's window is dropped once it holds no
+// open state, so keys that stop recurring cost nothing. The windows of all
+// keys number their results from one sequence, so a window_id identifies a
+// window across keys and is never reused.
+type KeyedWindowOperator struct {
+    name      string
+    field     string
+    newWindow func() Operator
+    windows   map[string]Operator
+    keys      []string  // of windows, sorted
+    ids       *windowIDs
+    watermark time.Time // latest pipeline watermark, zero if none
+}
+
+// idleWindow is implemented by windows that can tell when they hold no open
+// state, so a fresh window would behave the same.
+type idleWindow interface {
+    idle() bool
+}
+
+func NewKeyedWindowOperator(field string, newWindow func() Operator) *KeyedWindowOperator {
+    return &KeyedWindowOperator{
+        name:      newWindow().Name(),
+        field:     field,
+        newWindow: newWindow,
+        windows:   make(map[string]Operator),
+        ids:       &windowIDs{},
+    }
+}
+
+// Name is the name of the underlying window operator.
+func (op *KeyedWindowOperator) Name() string { return op.name }
+
+func (op *KeyedWindowOperator) window(key string) Operator {
+    w, ok := op.windows[key]
+    if !ok {
+        w = op.newWindow()
+        if s, ok := w.(sequenced); ok {
+            s.useIDs(op.ids)
+        }
+        op.windows[key] = w
+        i := sort.SearchStrings(op.keys, key)
+        op.keys = append(op.keys, "")
+        copy(op.keys[i+1:], op.keys[i:])
+        op.keys[i] = key
+        if h, ok := w.(WatermarkHandler); ok && !op.watermark.IsZero() {
+            // Nothing to fire yet; switches the new window to the pipeline's watermark.
+            h.OnWatermark(op.watermark)
+        }
+    }
+    return w
+}
+
+func (op *KeyedWindowOperator) Process(event model.Event) []model.Event {
+    key := fmt.Sprintf("%v", event.Data[op.field])
+    out := op.window(key).Process(event)
+    if w, ok := op.windows[key].(idleWindow); ok && w.idle() {
+        op.drop(sort.SearchStrings(op.keys, key))
+    }
+    return out
+}
+
+// drop forgets the window of the i-th key.
+func (op *KeyedWindowOperator) drop(i int) {
+    delete(op.windows, op.keys[i])
+    op.keys = append(op.keys[:i], op.keys[i+1:]...)
+}
+
+// each calls fn with every key's window, in key order, and drops the
+// windows left idle.
+func (op *KeyedWindowOperator) each(fn func(w Operator)) {
+    kept := op.keys[:0]
+    for _, k := range op.keys {
+        w := op.windows[k]
+        fn(w)
+        if iw, ok := w.(idleWindow); ok && iw.idle() {
+            delete(op.windows, k)
+            continue
+        }
+        kept = append(kept, k)
+    }
+    op.keys = kept
+}
+
+// Flush flushes every key's window, in key order.
+func (op *KeyedWindowOperator) Flush() []model.Event {
+    var out []model.Event
+    op.each(func(w Operator) {
+        if f, ok := w.(Flusher); ok {
+            out = append(out, f.Flush()...)
+        }
+    })
+    return out
+}
+
+// OnWatermark passes the pipeline's watermark to every key's window, in key
+// order.
+func (op *KeyedWindowOperator) OnWatermark(wm time.Time) []model.Event {
+    op.watermark = wm
+    var out []model.Event
+    op.each(func(w Operator) {
+        if h, ok := w.(WatermarkHandler); ok {
+            out = append(out, h.OnWatermark(wm)...)
+        }
+    })
+    return out
+}
+
+// OnTimer passes processing time to every key's window, in key order.
+func (op *KeyedWindowOperator) OnTimer(now time.Time) []model.Event {
+    var out []model.Event
+    op.each(func(w Operator) {
+        if t, ok := w.(Timer); ok {
+            out = append(out, t.OnTimer(now)...)
+        }
+    })
+    return out
+}
+
+// keyedWindowState is the state of every key's window, and the last
+// window_id the keys' windows drew.
+type keyedWindowState struct {
+    Windows  map[string]json.RawMessage `json:"windows"`
+    WindowID int                        `json:"window_id"`
+}
+
+func (op *KeyedWindowOperator) Snapshot() ([]byte, error) {
+    state := make(map[string]json.RawMessage, len(op.windows))
+    for k, w := range op.windows {
+        s, ok := w.(Snapshotter)
+        if !ok {
+            continue
+        }
+        data, err := s.Snapshot()
+        if err != nil {
+            return nil, fmt.Errorf("key %s: %w", k, err)
+        }
+        state[k] = data
+    }
+    return json.Marshal(keyedWindowState{Windows: state, WindowID: op.ids.last})
+}
+
+func (op *KeyedWindowOperator) Restore(data []byte) error {
+    var st keyedWindowState
+    if err := json.Unmarshal(data, &st); err != nil {
+        return err
+    }
+    op.windows = make(map[string]Operator, len(st.Windows))
+    op.keys = nil
+    op.ids = &windowIDs{last: st.WindowID}
+    for k, data := range st.Windows {
+        s, ok := op.window(k).(Snapshotter)
+        if !ok {
+            continue
+        }
+        if err := s.Restore(data); err != nil {
+            return fmt.Errorf("key %s: %w", k, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"testing"
+
+	"goxstream/internal/model"
+)
+
+// windowIDsOf lists the key and window_id of each result.
+func windowIDsOf(events []model.Event) [][2]interface{} {
+	var ids [][2]interface{}
+	for _, e := range events {
+		ids = append(ids, [2]interface{}{e.Data["k"], e.Data["window_id"]})
+	}
+	return ids
+}
+
+// Window IDs must stay unique across keys, and must not start over when an
+// idle key's window is dropped or the operator is restored.
+func TestKeyedWindowIDs(t *testing.T) {
+	params := map[string]interface{}{"duration": "10s", "key_by": "k", "inner": countBy("k")}
+	op := build(t, "time_window", params)
+	h := op.(WatermarkHandler)
+	h.OnWatermark(at(0))
+	op.Process(record(1, map[string]interface{}{"k": "a"}))
+	op.Process(record(2, map[string]interface{}{"k": "b"}))
+	got := windowIDsOf(h.OnWatermark(at(10)))
+	// Both windows fired and were dropped; a returns.
+	op.Process(record(11, map[string]interface{}{"k": "a"}))
+	got = append(got, windowIDsOf(h.OnWatermark(at(20)))...)
+
+	data, err := op.(Snapshotter).Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := build(t, "time_window", params)
+	if err := restored.(Snapshotter).Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	h = restored.(WatermarkHandler)
+	h.OnWatermark(at(20))
+	restored.Process(record(21, map[string]interface{}{"k": "b"}))
+	got = append(got, windowIDsOf(h.OnWatermark(at(30)))...)
+
+	want := [][2]interface{}{{"a", 1}, {"b", 2}, {"a", 3}, {"b", 4}}
+	if len(got) != len(want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("results = %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package operator
+
+import (
+    "context"
+    "fmt"
+    "goxstream/internal/model"
+    "goxstream/internal/source"
+    "sort"
+    "sync/atomic"
+    "time"
+)
+
+// LookupOperator enriches events with the columns of the reference table row
+// whose key matches theirs. The table is read from a file or db source spec
+// and, with refresh set, reloaded in the background every refresh; events
+// keep being enriched from the previous table meanwhile. Events without a
+// matching row are dropped, get null columns or get default values,
+// depending on onMiss. A failed reload keeps the previous table and counts
+// as a refresh error.
+type LookupOperator struct {
+    name          string
+    keys          []string // event key fields, matched against the table keys
+    onMiss        string   // drop, null or default
+    defaults      map[string]interface{}
+    src           *lookupSource
+    refreshErrors atomic.Int64 // failed reloads this operator started
+}
+
+// lookupSource holds a loaded table and reloads it. The parallel workers of
+// a node share one (see Replicate).
+type lookupSource struct {
+    load     func() (*lookupTable, error)
+    refresh  time.Duration
+    table    atomic.Pointer[lookupTable]
+    loadedAt time.Time // written only while holding loading
+    loading  atomic.Bool
+}
+
+func newLookupSource(refresh time.Duration, load func() (*lookupTable, error)) (*lookupSource, error) {
+    src := &lookupSource{load: load, refresh: refresh}
+    t, err := load()
+    if err != nil {
+        return nil, err
+    }
+    src.table.Store(t)
+    src.loadedAt = time.Now()
+    return src, nil
+}
+
+// reload starts a background reload of the table once it is older than
+// refresh. A failed reload keeps the current table and is counted in
+// errors.
+func (s *lookupSource) reload(now time.Time, errors *atomic.Int64) {
+    if s.refresh <= 0 || !s.loading.CompareAndSwap(false, true) {
+        return
+    }
+    if now.Sub(s.loadedAt) < s.refresh {
+        s.loading.Store(false)
+        return
+    }
+    s.loadedAt = now
+    go func() {
+        defer s.loading.Store(false)
+        t, err := s.load()
+        if err != nil {
+            errors.Add(1)
+            fmt.Println("lookup refresh error:", err)
+            return
+        }
+        s.table.Store(t)
+    }()
+}
+
+type lookupTable struct {
+    rows    map[string]map[string]interface{} // by key
+    columns []string                          // columns merged into events
+}
+
+// loadLookupTable reads a reference table from a source spec. The first row
+// of a key wins; columns defaults to every column but the keys.
+func loadLookupTable(spec map[string]interface{}, keys, columns []string) (*lookupTable, error) {
+    events := make(chan model.Event)
+    done := make(chan error, 1)
+    go func() { done <- source.BuildSource(context.Background(), spec, nil, nil, events) }()
+
+    t := &lookupTable{rows: make(map[string]map[string]interface{}), columns: columns}
+    for e := range events {
+        k := lookupKey(e.Data, keys)
+        if _, dup := t.rows[k]; !dup {
+            t.rows[k] = e.Data
+        }
+        if t.columns == nil {
+            t.columns = otherColumns(e.Data, keys)
+        }
+    }
+    if err := <-done; err != nil {
+        return nil, fmt.Errorf("lookup table: %w", err)
+    }
+    return t, nil
+}
+
+func lookupKey(data map[string]interface{}, fields []string) string {
+    key := make([]interface{}, len(fields))
+    for i, f := range fields {
+        key[i] = data[f]
+    }
+    return groupKeyOf(key)
+}
+
+func otherColumns(data map[string]interface{}, keys []string) []string {
+    isKey := make(map[string]bool, len(keys))
+    for _, k := range keys {
+        isKey[k] = true
+    }
+    var cols []string
+    for c := range data {
+        if !isKey[c] {
+            cols = append(cols, c)
+        }
+    }
+    sort.Strings(cols)
+    return cols
+}
+
+func NewLookupOperator(name string, keys []string, onMiss string, defaults map[string]interface{}, src *lookupSource) *LookupOperator {
+    return &LookupOperator{name: name, keys: keys, onMiss: onMiss, defaults: defaults, src: src}
+}
+
+func (op *LookupOperator) Name() string { return op.name }
+
+// Replicate returns another instance of the lookup that shares its table,
+// for a parallel worker of the same node.
+func (op *LookupOperator) Replicate() Operator {
+    return NewLookupOperator(op.name, op.keys, op.onMiss, op.defaults, op.src)
+}
+
+func (op *LookupOperator) Process(event model.Event) []model.Event {
+    t := op.src.table.Load()
+    row, ok := t.rows[lookupKey(event.Data, op.keys)]
+    if !ok && op.onMiss == "drop" {
+        return []model.Event{}
+    }
+    if event.Data == nil {
+        event.Data = make(map[string]interface{})
+    }
+    for _, c := range t.columns {
+        switch {
+        case ok:
+            event.Data[c] = row[c]
+        case op.onMiss == "default":
+            event.Data[c] = op.defaults[c]
+        default:
+            event.Data[c] = nil
+        }
+    }
+    return []model.Event{event}
+}
+
+// OnTimer reloads the table once it is older than refresh.
+func (op *LookupOperator) OnTimer(now time.Time) []model.Event {
+    op.src.reload(now, &op.refreshErrors)
+    return nil
+}
+
+// Counters reports the number of failed reloads of the table.
+func (op *LookupOperator) Counters() map[string]int64 {
+    return map[string]int64{"refresh_errors": op.refreshErrors.Load()}
+}
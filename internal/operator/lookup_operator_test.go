@@ -0,0 +1,67 @@
+package operator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// buildLookup builds a lookup of the city column in a table file that maps
+// cities to regions.
+func buildLookup(t *testing.T, params map[string]interface{}) (*LookupOperator, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cities.csv")
+	if err := os.WriteFile(path, []byte("city,region\nParis,FR\nLyon,FR\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := map[string]interface{}{"table": map[string]interface{}{"type": "file", "path": path}, "key": "city"}
+	for k, v := range params {
+		p[k] = v
+	}
+	return build(t, "lookup", p).(*LookupOperator), path
+}
+
+func region(t *testing.T, op Operator, city string) interface{} {
+	t.Helper()
+	out := op.Process(model.Event{Data: map[string]interface{}{"city": city}})
+	if len(out) != 1 {
+		t.Fatalf("lookup of %s = %v, want one event", city, out)
+	}
+	return out[0].Data["region"]
+}
+
+func TestLookupReplicateSharesTable(t *testing.T) {
+	op, _ := buildLookup(t, nil)
+	r, ok := op.Replicate().(*LookupOperator)
+	if !ok || r == op {
+		t.Fatalf("Replicate = %v, want another lookup", r)
+	}
+	if r.src != op.src {
+		t.Error("replica loaded its own table")
+	}
+	if got := region(t, r, "Lyon"); got != "FR" {
+		t.Errorf("region = %v, want FR", got)
+	}
+}
+
+// A failed reload keeps the table and is counted, not only logged.
+func TestLookupRefreshErrors(t *testing.T) {
+	op, path := buildLookup(t, map[string]interface{}{"refresh": "1ms"})
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	op.OnTimer(time.Now().Add(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for op.Counters()["refresh_errors"] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed reload was not counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := region(t, op, "Paris"); got != "FR" {
+		t.Errorf("region after a failed reload = %v, want FR", got)
+	}
+}
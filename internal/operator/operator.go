@@ -1,8 +1,53 @@
 package operator
 
-import "goxstream/internal/model"
+import (
+    "goxstream/internal/model"
+    "time"
+)
 
 type Operator interface {
     Name() string
     Process(event model.Event) []model.Event
 }
+
+// Flusher is implemented by operators that hold events back (e.g. windows).
+// Flush is called once at end of input and returns everything still pending.
+type Flusher interface {
+    Flush() []model.Event
+}
+
+// Snapshotter is implemented by stateful operators so their state can be
+// checkpointed and restored after a restart.
+type Snapshotter interface {
+    Snapshot() ([]byte, error)
+    Restore(data []byte) error
+}
+
+// WatermarkHandler is implemented by event-time operators. When the pipeline
+// generates watermarks, OnWatermark is called each time the watermark
+// advances and returns the results it completes; from the first call on, the
+// operator fires from the pipeline's watermark instead of its own.
+type WatermarkHandler interface {
+    OnWatermark(wm time.Time) []model.Event
+}
+
+// Timer is implemented by operators that act on processing time, such as
+// window triggers firing every interval. OnTimer is called periodically with
+// the current time and returns whatever fires.
+type Timer interface {
+    OnTimer(now time.Time) []model.Event
+}
+
+// Replicator is implemented by operators whose parallel instances can share
+// read-only resources, such as a loaded lookup table. Replicate returns a
+// new instance, with its own state, that shares them; the engine builds the
+// first worker of a node and replicates it for the others.
+type Replicator interface {
+    Replicate() Operator
+}
+
+// Counter is implemented by operators that count what they do, such as the
+// duplicates a dedup drops. Counters may be called while the pipeline runs.
+type Counter interface {
+    Counters() map[string]int64
+}
@@ -1,38 +1,165 @@
 package operator
 
 import (
+	"encoding/json"
 	"fmt"
 	"goxstream/internal/model"
+	"strings"
 )
 
+// BatchReduceOperator groups a batch of events by its key fields and
+// computes each aggregation per group. Group keys keep their original
+// values, and groups are emitted in order of first appearance.
 type BatchReduceOperator struct {
-	key string
-	agg string // For now, only "count" is implemented
+	keys []string
+	aggs []Aggregation
 }
 
-func NewBatchReduceOperator(key, agg string) *BatchReduceOperator {
-	return &BatchReduceOperator{key: key, agg: agg}
+func NewBatchReduceOperator(keys []string, aggs []Aggregation) *BatchReduceOperator {
+	return &BatchReduceOperator{keys: keys, aggs: aggs}
 }
 
 func (op *BatchReduceOperator) Name() string { return "batch_reduce" }
 
+// group is the running state of one group key.
+type group struct {
+	key  []interface{}
+	accs []accumulator
+}
+
+func groupKeyOf(key []interface{}) string {
+	parts := make([]string, len(key))
+	for i, v := range key {
+		parts[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func (op *BatchReduceOperator) add(g *group, e model.Event) {
+	for i, a := range op.aggs {
+		if a.Field == "" {
+			g.accs[i].add(nil)
+			continue
+		}
+		if v, ok := e.Data[a.Field]; ok && v != nil {
+			g.accs[i].add(v)
+		}
+	}
+}
+
+func (op *BatchReduceOperator) result(g *group) model.Event {
+	data := make(map[string]interface{}, len(op.keys)+len(op.aggs))
+	for i, k := range op.keys {
+		data[k] = g.key[i]
+	}
+	for i, a := range op.aggs {
+		data[a.As] = g.accs[i].result()
+	}
+	return model.Event{Data: data}
+}
+
 // This method is used by window/batch processors.
 func (op *BatchReduceOperator) ProcessBatch(events []model.Event) []model.Event {
-	groups := make(map[string]int)
+	acc := op.CreateAccumulator()
 	for _, e := range events {
-		k := fmt.Sprintf("%v", e.Data[op.key])
-		groups[k]++
+		acc.Add(e)
+	}
+	return acc.Result()
+}
+
+// CreateAccumulator returns the incremental form of the reduce: one set of
+// accumulators per group key.
+func (op *BatchReduceOperator) CreateAccumulator() Accumulator {
+	return &reduceAccumulator{op: op, groups: make(map[string]*group)}
+}
+
+type reduceAccumulator struct {
+	op     *BatchReduceOperator
+	groups map[string]*group
+	order  []string // group keys in order of first appearance
+}
+
+func (r *reduceAccumulator) group(k string, key []interface{}) *group {
+	g, ok := r.groups[k]
+	if !ok {
+		g = &group{key: key}
+		for _, a := range r.op.aggs {
+			g.accs = append(g.accs, a.create())
+		}
+		r.groups[k] = g
+		r.order = append(r.order, k)
 	}
+	return g
+}
+
+func (r *reduceAccumulator) Add(e model.Event) {
+	var key []interface{}
+	for _, k := range r.op.keys {
+		key = append(key, e.Data[k])
+	}
+	r.op.add(r.group(groupKeyOf(key), key), e)
+}
+
+func (r *reduceAccumulator) Merge(other Accumulator) {
+	o := other.(*reduceAccumulator)
+	for _, k := range o.order {
+		og := o.groups[k]
+		g := r.group(k, og.key)
+		for i, acc := range g.accs {
+			acc.merge(og.accs[i])
+		}
+	}
+}
+
+func (r *reduceAccumulator) Result() []model.Event {
 	var result []model.Event
-	for k, cnt := range groups {
-		result = append(result, model.Event{Data: map[string]interface{}{
-			op.key:  k,
-			"count": cnt,
-		}})
+	for _, k := range r.order {
+		result = append(result, r.op.result(r.groups[k]))
 	}
 	return result
 }
 
+type groupState struct {
+	Key  []interface{}     `json:"key"`
+	Aggs []json.RawMessage `json:"aggs"`
+}
+
+func (r *reduceAccumulator) MarshalJSON() ([]byte, error) {
+	groups := make([]groupState, 0, len(r.order))
+	for _, k := range r.order {
+		g := r.groups[k]
+		st := groupState{Key: g.key}
+		for _, acc := range g.accs {
+			data, err := json.Marshal(acc)
+			if err != nil {
+				return nil, err
+			}
+			st.Aggs = append(st.Aggs, data)
+		}
+		groups = append(groups, st)
+	}
+	return json.Marshal(groups)
+}
+
+func (r *reduceAccumulator) UnmarshalJSON(data []byte) error {
+	var groups []groupState
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return err
+	}
+	for _, st := range groups {
+		if len(st.Aggs) != len(r.op.aggs) {
+			return fmt.Errorf("reduce state has %d aggregations, expected %d", len(st.Aggs), len(r.op.aggs))
+		}
+		g := r.group(groupKeyOf(st.Key), st.Key)
+		for i, raw := range st.Aggs {
+			if err := json.Unmarshal(raw, g.accs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Process is not used in batch mode but needs to be defined.
 func (op *BatchReduceOperator) Process(e model.Event) []model.Event {
 	panic("BatchReduceOperator.Process should not be called; use ProcessBatch instead")
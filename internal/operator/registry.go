@@ -2,6 +2,7 @@ package operator
 
 import (
 	"fmt"
+	"goxstream/internal/expr"
 	"goxstream/internal/model"
 	"time"
 )
@@ -15,14 +16,33 @@ func init() {
 		"map":                   mapOperatorFactory,
 		"filter":                filterOperatorFactory,
 		"reduce":                reduceOperatorFactory,
+		"key_by":                keyByOperatorFactory,
 		"tumbling_window":       tumblingWindowOperatorFactory,
 		"sliding_window":        slidingWindowOperatorFactory,
 		"time_window":           timeWindowOperatorFactory,           // basic time window (if you have it)
 		"time_sliding_window":   timeSlidingWindowOperatorFactory,    // time-based sliding window
 		"time_window_watermark": timeWindowWatermarkFactory,          // watermark support!
+		"session_window":        sessionWindowFactory,                // per-key sessions closed by an inactivity gap
+		"join":                  joinOperatorFactory,                 // two-input stream join
+		"lookup":                lookupOperatorFactory,               // enrich from a reference table
+		"enrich":                lookupOperatorFactory,
+		"dedup":                 dedupOperatorFactory,                // drop events seen within a ttl
 	}
 }
 
+// sideOutputs lists the side outputs of the operator types that have any.
+var sideOutputs = map[string][]string{
+	"time_window":           {LateOutput},
+	"time_sliding_window":   {LateOutput},
+	"time_window_watermark": {LateOutput},
+	"session_window":        {LateOutput},
+	"join":                  {LateOutput},
+}
+
+// SideOutputs returns the side outputs an operator of type opType can emit
+// records on, besides its main output.
+func SideOutputs(opType string) []string { return sideOutputs[opType] }
+
 func BuildOperator(opSpec model.OperatorSpec) (Operator, error) {
 	factory, ok := registry[opSpec.Type]
 	if !ok {
@@ -41,13 +61,41 @@ func toInt(val interface{}) (int, bool) {
 	return 0, false
 }
 
+// parseLateOutput reads where an operator puts events too late for their
+// window: "tag" (default) keeps them on the main output with late=true, and
+// "side" emits them on the LateOutput side output. The pipeline planner
+// selects "side" for a node whose late output has an edge.
+func parseLateOutput(params map[string]interface{}) (string, error) {
+	lateOutput, _ := params["late_output"].(string)
+	switch lateOutput {
+	case "":
+		return "tag", nil
+	case "side", "tag":
+		return lateOutput, nil
+	}
+	return "", fmt.Errorf("late_output must be side or tag, got %q", lateOutput)
+}
+
 // ----- Basic Operators -----
 
 func mapOperatorFactory(params map[string]interface{}) (Operator, error) {
-	col, ok1 := params["col"].(string)
-	val, ok2 := params["val"]
-	if !ok1 || !ok2 {
-		return nil, fmt.Errorf("map operator expects col and val")
+	col, ok := params["col"].(string)
+	if !ok {
+		return nil, fmt.Errorf("map operator expects col and val or expr")
+	}
+	if src, ok := params["expr"].(string); ok && src != "" {
+		prog, err := expr.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("map operator: %w", err)
+		}
+		return NewMapOperator("map", func(e model.Event) model.Event {
+			e.Data[col] = prog.Eval(e.Data)
+			return e
+		}), nil
+	}
+	val, ok := params["val"]
+	if !ok {
+		return nil, fmt.Errorf("map operator expects col and val or expr")
 	}
 	return NewMapOperator("map", func(e model.Event) model.Event {
 		e.Data[col] = val
@@ -56,23 +104,106 @@ func mapOperatorFactory(params map[string]interface{}) (Operator, error) {
 }
 
 func filterOperatorFactory(params map[string]interface{}) (Operator, error) {
+	if src, ok := params["expr"].(string); ok && src != "" {
+		prog, err := expr.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("filter operator: %w", err)
+		}
+		return NewFilterOperator("filter", func(e model.Event) bool {
+			return prog.Match(e.Data)
+		}), nil
+	}
 	field, ok1 := params["field"].(string)
 	eq, eqOk := params["eq"]
 	if ok1 && eqOk {
 		return NewFilterOperator("filter", func(e model.Event) bool {
-			return e.Data[field] == eq
+			return expr.Equal(e.Data[field], eq)
 		}), nil
 	}
-	return nil, fmt.Errorf("filter operator expects field and eq")
+	return nil, fmt.Errorf("filter operator expects expr, or field and eq")
 }
 
+// reduceOperatorFactory accepts a single aggregation inline,
+// { "key": "city", "agg": "sum", "field": "score" }, or several in "aggs";
+// "key" may list several fields for a composite group key.
 func reduceOperatorFactory(params map[string]interface{}) (Operator, error) {
-	key, ok1 := params["key"].(string)
-	agg, ok2 := params["agg"].(string)
-	if !ok1 || !ok2 {
-		return nil, fmt.Errorf("reduce operator expects key and agg")
+	var keys []string
+	if k := params["key"]; k != nil && k != "" {
+		var ok bool
+		if keys, ok = fieldNames(k); !ok {
+			return nil, fmt.Errorf("reduce operator key must be a field name or list of field names")
+		}
+	}
+
+	var specs []map[string]interface{}
+	if _, ok := params["agg"]; ok {
+		specs = append(specs, params)
+	}
+	if list, ok := params["aggs"].([]interface{}); ok {
+		for _, a := range list {
+			spec, ok := a.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("reduce operator aggs must be objects")
+			}
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("reduce operator expects agg or aggs")
+	}
+	var aggs []Aggregation
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		a, err := parseAggregation(spec)
+		if err != nil {
+			return nil, fmt.Errorf("reduce operator: %w", err)
+		}
+		if seen[a.As] {
+			return nil, fmt.Errorf("reduce operator: duplicate output column %s", a.As)
+		}
+		seen[a.As] = true
+		aggs = append(aggs, a)
+	}
+	return NewBatchReduceOperator(keys, aggs), nil
+}
+
+// fieldNames reads a field name or a list of field names.
+func fieldNames(v interface{}) ([]string, bool) {
+	switch k := v.(type) {
+	case string:
+		return []string{k}, k != ""
+	case []interface{}:
+		var names []string
+		for _, f := range k {
+			name, ok := f.(string)
+			if !ok || name == "" {
+				return nil, false
+			}
+			names = append(names, name)
+		}
+		return names, len(names) > 0
+	}
+	return nil, false
+}
+
+func keyByOperatorFactory(params map[string]interface{}) (Operator, error) {
+	field, ok := params["field"].(string)
+	if !ok {
+		return nil, fmt.Errorf("key_by operator expects field")
+	}
+	return NewKeyByOperator(field), nil
+}
+
+// windowed builds a window operator with newWindow, once per key if params
+// has a key_by field. The window's inner operator must aggregate.
+func windowed(params map[string]interface{}, inner Operator, newWindow func() Operator) (Operator, error) {
+	if err := checkAggregates(inner); err != nil {
+		return nil, err
+	}
+	if field, ok := params["key_by"].(string); ok && field != "" {
+		return NewKeyedWindowOperator(field, newWindow), nil
 	}
-	return NewBatchReduceOperator(key, agg), nil
+	return newWindow(), nil
 }
 
 // ----- Count-based Windows -----
@@ -92,7 +223,9 @@ func tumblingWindowOperatorFactory(params map[string]interface{}) (Operator, err
 	if err != nil {
 		return nil, fmt.Errorf("tumbling window inner op error: %w", err)
 	}
-	return NewTumblingWindowOperator("tumbling_window", size, innerOp), nil
+	return windowed(params, innerOp, func() Operator {
+		return NewTumblingWindowOperator("tumbling_window", size, innerOp)
+	})
 }
 
 func slidingWindowOperatorFactory(params map[string]interface{}) (Operator, error) {
@@ -102,6 +235,9 @@ func slidingWindowOperatorFactory(params map[string]interface{}) (Operator, erro
 	if !ok1 || !ok2 || !ok3 {
 		return nil, fmt.Errorf("sliding window expects size, step, inner operator")
 	}
+	if size <= 0 || step <= 0 {
+		return nil, fmt.Errorf("sliding window size and step must be positive")
+	}
 	innerType, ok4 := innerSpec["type"].(string)
 	innerParams, ok5 := innerSpec["params"].(map[string]interface{})
 	if !ok4 || !ok5 {
@@ -111,7 +247,9 @@ func slidingWindowOperatorFactory(params map[string]interface{}) (Operator, erro
 	if err != nil {
 		return nil, fmt.Errorf("sliding window inner op error: %w", err)
 	}
-	return NewSlidingWindowOperator("sliding_window", size, step, innerOp), nil
+	return windowed(params, innerOp, func() Operator {
+		return NewSlidingWindowOperator("sliding_window", size, step, innerOp)
+	})
 }
 
 // ----- Time-based Windows -----
@@ -126,6 +264,14 @@ func timeWindowOperatorFactory(params map[string]interface{}) (Operator, error)
 	if err != nil {
 		return nil, fmt.Errorf("invalid duration: %w", err)
 	}
+	lateOutput, err := parseLateOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	trigger, err := parseTrigger(params["trigger"])
+	if err != nil {
+		return nil, err
+	}
 	innerType, ok3 := innerSpec["type"].(string)
 	innerParams, ok4 := innerSpec["params"].(map[string]interface{})
 	if !ok3 || !ok4 {
@@ -136,7 +282,9 @@ func timeWindowOperatorFactory(params map[string]interface{}) (Operator, error)
 		return nil, fmt.Errorf("time window inner op error: %w", err)
 	}
 	// This must match your operator's constructor for basic time window:
-	return NewTimeWindowOperator("time_window", dur, innerOp), nil
+	return windowed(params, innerOp, func() Operator {
+		return NewTimeWindowOperator("time_window", dur, lateOutput, trigger, innerOp)
+	})
 }
 
 func timeSlidingWindowOperatorFactory(params map[string]interface{}) (Operator, error) {
@@ -146,6 +294,9 @@ func timeSlidingWindowOperatorFactory(params map[string]interface{}) (Operator,
 	if !ok1 || !ok2 || !ok3 {
 		return nil, fmt.Errorf("time sliding window expects size, slide, inner")
 	}
+	if _, ok := params["trigger"]; ok {
+		return nil, fmt.Errorf("time sliding window does not support triggers")
+	}
 	windowSize, err := time.ParseDuration(windowSizeStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid window size: %w", err)
@@ -154,6 +305,13 @@ func timeSlidingWindowOperatorFactory(params map[string]interface{}) (Operator,
 	if err != nil {
 		return nil, fmt.Errorf("invalid slide: %w", err)
 	}
+	if windowSize <= 0 || slide <= 0 {
+		return nil, fmt.Errorf("time sliding window size and slide must be positive")
+	}
+	lateOutput, err := parseLateOutput(params)
+	if err != nil {
+		return nil, err
+	}
 	innerType, ok4 := innerSpec["type"].(string)
 	innerParams, ok5 := innerSpec["params"].(map[string]interface{})
 	if !ok4 || !ok5 {
@@ -163,7 +321,9 @@ func timeSlidingWindowOperatorFactory(params map[string]interface{}) (Operator,
 	if err != nil {
 		return nil, fmt.Errorf("time sliding window inner op error: %w", err)
 	}
-	return NewTimeSlidingWindowOperator("time_sliding_window", windowSize, slide, innerOp), nil
+	return windowed(params, innerOp, func() Operator {
+		return NewTimeSlidingWindowOperator("time_sliding_window", windowSize, slide, lateOutput, innerOp)
+	})
 }
 
 // ----- Watermarking Window -----
@@ -183,6 +343,21 @@ func timeWindowWatermarkFactory(params map[string]interface{}) (Operator, error)
 	if err != nil {
 		return nil, fmt.Errorf("invalid lateness: %w", err)
 	}
+	var outOfOrderness time.Duration
+	if s, ok := params["out_of_orderness"].(string); ok {
+		outOfOrderness, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid out_of_orderness: %w", err)
+		}
+	}
+	lateOutput, err := parseLateOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	trigger, err := parseTrigger(params["trigger"])
+	if err != nil {
+		return nil, err
+	}
 	innerType, ok4 := innerSpec["type"].(string)
 	innerParams, ok5 := innerSpec["params"].(map[string]interface{})
 	if !ok4 || !ok5 {
@@ -192,5 +367,211 @@ func timeWindowWatermarkFactory(params map[string]interface{}) (Operator, error)
 	if err != nil {
 		return nil, fmt.Errorf("inner op error: %w", err)
 	}
-	return NewTimeWindowWithWatermarkOperator("time_window_watermark", dur, lateness, innerOp), nil
+	return windowed(params, innerOp, func() Operator {
+		return NewTimeWindowWithWatermarkOperator("time_window_watermark", dur, outOfOrderness, lateness, lateOutput, trigger, innerOp)
+	})
+}
+
+// ----- Session Window -----
+
+func sessionWindowFactory(params map[string]interface{}) (Operator, error) {
+	gapStr, ok1 := params["gap"].(string)
+	innerSpec, ok2 := params["inner"].(map[string]interface{})
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("session window expects gap and inner")
+	}
+	if _, ok := params["trigger"]; ok {
+		return nil, fmt.Errorf("session window does not support triggers")
+	}
+	gap, err := time.ParseDuration(gapStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gap: %w", err)
+	}
+	if gap <= 0 {
+		return nil, fmt.Errorf("session window gap must be positive")
+	}
+	var lateness time.Duration
+	if latenessStr, ok := params["allowed_lateness"].(string); ok {
+		lateness, err = time.ParseDuration(latenessStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lateness: %w", err)
+		}
+	}
+	keyField, _ := params["key_by"].(string)
+	lateOutput, err := parseLateOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	innerType, ok3 := innerSpec["type"].(string)
+	innerParams, ok4 := innerSpec["params"].(map[string]interface{})
+	if !ok3 || !ok4 {
+		return nil, fmt.Errorf("session window inner must have type and params")
+	}
+	innerOp, err := BuildOperator(model.OperatorSpec{Type: innerType, Params: innerParams})
+	if err != nil {
+		return nil, fmt.Errorf("session window inner op error: %w", err)
+	}
+	if err := checkAggregates(innerOp); err != nil {
+		return nil, fmt.Errorf("session window: %w", err)
+	}
+	return NewSessionWindowOperator("session_window", gap, lateness, keyField, lateOutput, innerOp), nil
+}
+
+// ----- Join -----
+
+// joinOperatorFactory expects the node IDs of both inputs, the key and an
+// interval or a window, e.g.
+// { "left": "orders", "right": "payments", "key": "order_id", "interval": "10m", "join": "left" }.
+// "left_key" and "right_key" replace "key" when the sides name it differently.
+func joinOperatorFactory(params map[string]interface{}) (Operator, error) {
+	left, ok1 := params["left"].(string)
+	right, ok2 := params["right"].(string)
+	if !ok1 || !ok2 || left == "" || right == "" || left == right {
+		return nil, fmt.Errorf("join expects distinct left and right input nodes")
+	}
+	var leftKey, rightKey []string
+	if k := params["key"]; k != nil {
+		keys, ok := fieldNames(k)
+		if !ok {
+			return nil, fmt.Errorf("join key must be a field name or list of field names")
+		}
+		leftKey, rightKey = keys, keys
+	} else {
+		var ok3, ok4 bool
+		leftKey, ok3 = fieldNames(params["left_key"])
+		rightKey, ok4 = fieldNames(params["right_key"])
+		if !ok3 || !ok4 {
+			return nil, fmt.Errorf("join expects key, or left_key and right_key")
+		}
+		if len(leftKey) != len(rightKey) {
+			return nil, fmt.Errorf("join left_key and right_key must have the same number of fields")
+		}
+	}
+	joinType, _ := params["join"].(string)
+	switch joinType {
+	case "":
+		joinType = "inner"
+	case "inner", "left", "full":
+	default:
+		return nil, fmt.Errorf("join must be inner, left or full, got %q", joinType)
+	}
+	lateOutput, err := parseLateOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	intervalStr, hasInterval := params["interval"].(string)
+	windowStr, hasWindow := params["window"].(string)
+	if hasInterval == hasWindow {
+		return nil, fmt.Errorf("join expects either interval or window")
+	}
+	var interval, window time.Duration
+	if hasInterval {
+		interval, err = time.ParseDuration(intervalStr)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid join interval: %q", intervalStr)
+		}
+	} else {
+		window, err = time.ParseDuration(windowStr)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid join window: %q", windowStr)
+		}
+	}
+	return NewJoinOperator("join", left, right, leftKey, rightKey, joinType, lateOutput, interval, window), nil
+}
+
+// ----- Lookup -----
+
+// lookupOperatorFactory expects a file or db source spec as "table" and the
+// event key, e.g.
+// { "table": { "type": "db", "dsn": "...", "query": "SELECT city, region FROM cities" },
+//   "key": "city", "on_miss": "default", "default": { "region": "unknown" }, "refresh": "10m" }.
+// "table_key" names the table's key columns when they differ from the
+// event's, and "columns" limits the columns merged into events.
+func lookupOperatorFactory(params map[string]interface{}) (Operator, error) {
+	table, ok := params["table"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("lookup expects table")
+	}
+	if t, _ := table["type"].(string); t != "file" && t != "db" {
+		return nil, fmt.Errorf("lookup table must be a file or db source, got %q", t)
+	}
+	if follow, _ := table["follow"].(bool); follow {
+		return nil, fmt.Errorf("lookup table cannot follow a file")
+	}
+	keys, ok := fieldNames(params["key"])
+	if !ok {
+		return nil, fmt.Errorf("lookup expects key")
+	}
+	tableKeys := keys
+	if k, ok := params["table_key"]; ok {
+		if tableKeys, ok = fieldNames(k); !ok || len(tableKeys) != len(keys) {
+			return nil, fmt.Errorf("lookup table_key must name as many fields as key")
+		}
+	}
+	var columns []string
+	if c, ok := params["columns"]; ok {
+		if columns, ok = fieldNames(c); !ok {
+			return nil, fmt.Errorf("lookup columns must be a field name or list of field names")
+		}
+	}
+	onMiss, _ := params["on_miss"].(string)
+	switch onMiss {
+	case "":
+		onMiss = "null"
+	case "drop", "null", "default":
+	default:
+		return nil, fmt.Errorf("lookup on_miss must be drop, null or default, got %q", onMiss)
+	}
+	defaults, _ := params["default"].(map[string]interface{})
+	if onMiss == "default" && defaults == nil {
+		return nil, fmt.Errorf("lookup on_miss default expects default values")
+	}
+	var refresh time.Duration
+	if r, ok := params["refresh"].(string); ok {
+		d, err := time.ParseDuration(r)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid lookup refresh: %q", r)
+		}
+		refresh = d
+	}
+	src, err := newLookupSource(refresh, func() (*lookupTable, error) {
+		return loadLookupTable(table, tableKeys, columns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewLookupOperator("lookup", keys, onMiss, defaults, src), nil
+}
+
+// dedupOperatorFactory expects a "ttl", a "max_keys" bound or both, e.g.
+// { "key": ["order_id", "line"], "ttl": "10m", "max_keys": 100000 }.
+// Without "key", events are keyed by a hash of the whole record.
+func dedupOperatorFactory(params map[string]interface{}) (Operator, error) {
+	var keys []string
+	if k := params["key"]; k != nil {
+		var ok bool
+		if keys, ok = fieldNames(k); !ok {
+			return nil, fmt.Errorf("dedup key must be a field name or list of field names")
+		}
+	}
+	var ttl time.Duration
+	if t, ok := params["ttl"].(string); ok {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid dedup ttl: %q", t)
+		}
+		ttl = d
+	}
+	var maxKeys int
+	if m, ok := params["max_keys"]; ok {
+		n, ok := toInt(m)
+		if !ok || n < 1 {
+			return nil, fmt.Errorf("dedup max_keys must be a positive integer")
+		}
+		maxKeys = n
+	}
+	if ttl == 0 && maxKeys == 0 {
+		return nil, fmt.Errorf("dedup expects ttl or max_keys")
+	}
+	return NewDedupOperator("dedup", keys, ttl, maxKeys), nil
 }
@@ -0,0 +1,178 @@
+package operator
+
+import (
+    "fmt"
+    "goxstream/internal/model"
+    "sort"
+    "time"
+)
+
+// SessionWindowOperator groups events per key into sessions that close
+// after gap of inactivity. A session spans [first event, last event + gap);
+// an event that falls within gap of two sessions merges them. Like the
+// watermark window, the watermark is the max event time minus
+// allowedLateness unless the pipeline provides one, and sessions are emitted
+// once their end passes it. An event too late to join any open session is
+// tagged with late=true, or emitted on the LateOutput side output if
+// lateOutput is "side".
+type SessionWindowOperator struct {
+    name            string
+    gap             time.Duration
+    allowedLateness time.Duration
+    keyField        string // empty: one session stream for all events
+    lateOutput      string
+    sessions        map[string][]*session // open sessions per key, by start
+    maxEventTime    time.Time
+    watermark       time.Time
+    streamWatermark bool // use the pipeline's watermark
+    agg             Aggregator
+    inner           Operator
+    windowID        int
+}
+
+type session struct {
+    start, end time.Time
+    acc        *pane
+}
+
+func NewSessionWindowOperator(name string, gap, allowedLateness time.Duration, keyField, lateOutput string, inner Operator) *SessionWindowOperator {
+    return &SessionWindowOperator{
+        name:            name,
+        gap:             gap,
+        allowedLateness: allowedLateness,
+        keyField:        keyField,
+        lateOutput:      lateOutput,
+        sessions:        make(map[string][]*session),
+        agg:             aggregatorFor(inner),
+        inner:           inner,
+    }
+}
+
+func (op *SessionWindowOperator) Name() string { return op.name }
+
+func (op *SessionWindowOperator) key(event model.Event) string {
+    if op.keyField == "" {
+        return ""
+    }
+    return fmt.Sprintf("%v", event.Data[op.keyField])
+}
+
+func (op *SessionWindowOperator) Process(event model.Event) []model.Event {
+    ts := event.Timestamp
+    k := op.key(event)
+
+    // Merge the new event's session with every open session it overlaps.
+    s := &session{start: ts, end: ts.Add(op.gap), acc: newPane(op.agg)}
+    s.acc.add(event)
+    var keep, merged []*session
+    for _, o := range op.sessions[k] {
+        if o.start.Before(s.end) && s.start.Before(o.end) {
+            merged = append(merged, o)
+        } else {
+            keep = append(keep, o)
+        }
+    }
+    if len(merged) > 0 {
+        merged = append(merged, s)
+        sort.Slice(merged, func(i, j int) bool { return merged[i].start.Before(merged[j].start) })
+        var panes []*pane
+        for _, o := range merged {
+            if o.start.Before(s.start) {
+                s.start = o.start
+            }
+            if o.end.After(s.end) {
+                s.end = o.end
+            }
+            panes = append(panes, o.acc)
+        }
+        s.acc = mergePanes(op.agg, panes)
+    }
+
+    if ts.After(op.maxEventTime) {
+        op.maxEventTime = ts
+        if !op.streamWatermark {
+            op.watermark = op.maxEventTime.Add(-op.allowedLateness)
+        }
+    }
+    // A late event that bridges no open session is passed on as late: its
+    // session would already be closed.
+    out := []model.Event{}
+    if len(merged) > 0 || s.end.After(op.watermark) {
+        keep = append(keep, s)
+        sort.Slice(keep, func(i, j int) bool { return keep[i].start.Before(keep[j].start) })
+    } else {
+        out = append(out, lateEvent(event, op.lateOutput))
+    }
+    if len(keep) > 0 {
+        op.sessions[k] = keep
+    }
+    return append(out, op.emitClosed()...)
+}
+
+func (op *SessionWindowOperator) OnWatermark(wm time.Time) []model.Event {
+    op.streamWatermark = true
+    if wm.After(op.watermark) {
+        op.watermark = wm
+    }
+    return op.emitClosed()
+}
+
+// emitClosed emits the sessions the watermark has passed.
+func (op *SessionWindowOperator) emitClosed() []model.Event {
+    return op.emit(func(s *session) bool { return !s.end.After(op.watermark) }, false)
+}
+
+// emit closes the sessions matching done, in order of their end time.
+func (op *SessionWindowOperator) emit(done func(*session) bool, flush bool) []model.Event {
+    type closed struct {
+        key string
+        s   *session
+    }
+    var ready []closed
+    for k, sessions := range op.sessions {
+        var open []*session
+        for _, s := range sessions {
+            if done(s) {
+                ready = append(ready, closed{k, s})
+            } else {
+                open = append(open, s)
+            }
+        }
+        if len(open) == 0 {
+            delete(op.sessions, k)
+        } else {
+            op.sessions[k] = open
+        }
+    }
+    sort.Slice(ready, func(i, j int) bool {
+        a, b := ready[i].s, ready[j].s
+        if !a.end.Equal(b.end) {
+            return a.end.Before(b.end)
+        }
+        return ready[i].key < ready[j].key
+    })
+
+    out := []model.Event{}
+    for _, c := range ready {
+        op.windowID++
+        results := c.s.acc.acc.Result()
+        for i := range results {
+            if results[i].Data == nil {
+                results[i].Data = make(map[string]interface{})
+            }
+            results[i].Data["session_start"] = c.s.start.Format(time.RFC3339)
+            results[i].Data["session_end"] = c.s.end.Format(time.RFC3339)
+            results[i].Data["window_id"] = op.windowID
+            results[i].Timestamp = windowTime(c.s.end)
+            if flush {
+                results[i].Data["emitted_via_flush"] = true
+            }
+        }
+        out = append(out, results...)
+    }
+    return out
+}
+
+func (op *SessionWindowOperator) Flush() []model.Event {
+    return op.emit(func(*session) bool { return true }, true)
+}
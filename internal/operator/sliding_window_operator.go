@@ -4,50 +4,64 @@ import (
     "goxstream/internal/model"
 )
 
+// SlidingWindowOperator emits a window of the last size events every step
+// events. Events are pre-aggregated into panes of gcd(size, step) events,
+// so only size/gcd pane accumulators are kept.
 type SlidingWindowOperator struct {
     name      string
     size      int
     step      int
-    buffer    []model.Event
+    paneSize  int
+    panes     []*pane // completed panes, oldest first
+    current   *pane
     eventSeen int
-    windowID  int
+    ids       *windowIDs
+    agg       Aggregator
     inner     Operator // Should support ProcessBatch([]model.Event) []model.Event
 }
 
 func NewSlidingWindowOperator(name string, size, step int, inner Operator) *SlidingWindowOperator {
     return &SlidingWindowOperator{
-        name:   name,
-        size:   size,
-        step:   step,
-        buffer: []model.Event{},
-        inner:  inner,
+        name:     name,
+        size:     size,
+        step:     step,
+        paneSize: int(gcd(int64(size), int64(step))),
+        ids:      &windowIDs{},
+        inner:    inner,
+        agg:      aggregatorFor(inner),
     }
 }
 
 func (op *SlidingWindowOperator) Name() string { return op.name }
 
 func (op *SlidingWindowOperator) Process(event model.Event) []model.Event {
-    op.buffer = append(op.buffer, event)
+    if op.current == nil {
+        op.current = newPane(op.agg)
+    }
+    op.current.add(event)
     op.eventSeen++
-    if len(op.buffer) > op.size {
-        op.buffer = op.buffer[1:]
+    if op.current.n < op.paneSize {
+        return nil
+    }
+    op.panes = append(op.panes, op.current)
+    op.current = nil
+    if len(op.panes) > op.size/op.paneSize {
+        op.panes = op.panes[1:]
     }
 
-    if len(op.buffer) == op.size && ((op.eventSeen-op.size)%op.step == 0) {
-        if batcher, ok := op.inner.(interface {
-            ProcessBatch([]model.Event) []model.Event
-        }); ok {
-            op.windowID++
-            out := batcher.ProcessBatch(op.buffer)
-            // Annotate each result with the window ID
-            for i := range out {
-                if out[i].Data == nil {
-                    out[i].Data = make(map[string]interface{})
-                }
-                out[i].Data["window_id"] = op.windowID
+    if op.eventSeen >= op.size && ((op.eventSeen-op.size)%op.step == 0) {
+        id := op.ids.next()
+        out := mergePanes(op.agg, op.panes).acc.Result()
+        // Annotate each result with the window ID
+        for i := range out {
+            if out[i].Data == nil {
+                out[i].Data = make(map[string]interface{})
             }
-            return out
+            out[i].Data["window_id"] = id
         }
+        return out
     }
     return nil
 }
+
+func (op *SlidingWindowOperator) useIDs(ids *windowIDs) { op.ids = ids }
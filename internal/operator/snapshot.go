@@ -0,0 +1,324 @@
+package operator
+
+import (
+	"encoding/json"
+	"goxstream/internal/model"
+	"time"
+)
+
+// Operator state is checkpointed as JSON. Windows save the accumulators of
+// their open windows and panes; values survive the round trip with JSON
+// types, so numbers come back as float64.
+
+// paneOrNil snapshots an optional pane.
+func paneOrNil(p *pane) (*paneState, error) {
+	if p == nil {
+		return nil, nil
+	}
+	st, err := p.state()
+	return &st, err
+}
+
+func restorePaneOrNil(agg Aggregator, st *paneState) (*pane, error) {
+	if st == nil {
+		return nil, nil
+	}
+	return restorePane(agg, *st)
+}
+
+func paneStates(panes map[time.Time]*pane) (map[time.Time]paneState, error) {
+	out := make(map[time.Time]paneState, len(panes))
+	for k, p := range panes {
+		st, err := p.state()
+		if err != nil {
+			return nil, err
+		}
+		out[k] = st
+	}
+	return out, nil
+}
+
+func restorePanes(agg Aggregator, states map[time.Time]paneState) (map[time.Time]*pane, error) {
+	out := make(map[time.Time]*pane, len(states))
+	for k, st := range states {
+		p, err := restorePane(agg, st)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, nil
+}
+
+type tumblingWindowState struct {
+	Window *paneState `json:"window"`
+}
+
+func (op *TumblingWindowOperator) Snapshot() ([]byte, error) {
+	w, err := paneOrNil(op.window)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tumblingWindowState{Window: w})
+}
+
+func (op *TumblingWindowOperator) Restore(data []byte) error {
+	var st tumblingWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	w, err := restorePaneOrNil(op.agg, st.Window)
+	if err != nil {
+		return err
+	}
+	op.window = w
+	return nil
+}
+
+type slidingWindowState struct {
+	Panes     []paneState `json:"panes"`
+	Current   *paneState  `json:"current"`
+	EventSeen int         `json:"event_seen"`
+	WindowID  int         `json:"window_id"`
+}
+
+func (op *SlidingWindowOperator) Snapshot() ([]byte, error) {
+	st := slidingWindowState{EventSeen: op.eventSeen, WindowID: op.ids.last}
+	for _, p := range op.panes {
+		ps, err := p.state()
+		if err != nil {
+			return nil, err
+		}
+		st.Panes = append(st.Panes, ps)
+	}
+	var err error
+	if st.Current, err = paneOrNil(op.current); err != nil {
+		return nil, err
+	}
+	return json.Marshal(st)
+}
+
+func (op *SlidingWindowOperator) Restore(data []byte) error {
+	var st slidingWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	op.panes = nil
+	for _, ps := range st.Panes {
+		p, err := restorePane(op.agg, ps)
+		if err != nil {
+			return err
+		}
+		op.panes = append(op.panes, p)
+	}
+	current, err := restorePaneOrNil(op.agg, st.Current)
+	if err != nil {
+		return err
+	}
+	op.current, op.eventSeen = current, st.EventSeen
+	op.ids.restore(st.WindowID)
+	return nil
+}
+
+type timeWindowState struct {
+	WindowEnd       time.Time                  `json:"window_end"`
+	Windows         map[time.Time]paneState    `json:"windows"`
+	StreamWatermark bool                       `json:"stream_watermark"`
+	Early           map[time.Time]*earlyFiring `json:"early,omitempty"`
+	WindowID        int                        `json:"window_id"`
+}
+
+// earlyFirings returns the early firings of a triggered window to save.
+func earlyFirings(t *triggerState) map[time.Time]*earlyFiring {
+	if t == nil {
+		return nil
+	}
+	return t.early
+}
+
+func restoreEarlyFirings(t *triggerState, early map[time.Time]*earlyFiring) {
+	if t == nil {
+		return
+	}
+	if early == nil {
+		early = make(map[time.Time]*earlyFiring)
+	}
+	t.early = early
+}
+
+func (op *TimeWindowOperator) Snapshot() ([]byte, error) {
+	windows, err := paneStates(op.windows)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(timeWindowState{
+		WindowEnd:       op.windowEnd,
+		Windows:         windows,
+		StreamWatermark: op.streamWatermark,
+		Early:           earlyFirings(op.trigger),
+		WindowID:        op.ids.last,
+	})
+}
+
+func (op *TimeWindowOperator) Restore(data []byte) error {
+	var st timeWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	windows, err := restorePanes(op.agg, st.Windows)
+	if err != nil {
+		return err
+	}
+	op.windowEnd, op.windows, op.streamWatermark = st.WindowEnd, windows, st.StreamWatermark
+	op.ids.restore(st.WindowID)
+	restoreEarlyFirings(op.trigger, st.Early)
+	return nil
+}
+
+type timeSlidingWindowState struct {
+	NextWindowEnd   time.Time               `json:"next_window_end"`
+	MaxTime         time.Time               `json:"max_time"`
+	Panes           map[time.Time]paneState `json:"panes"`
+	StreamWatermark bool                    `json:"stream_watermark"`
+	WindowID        int                     `json:"window_id"`
+}
+
+func (op *TimeSlidingWindowOperator) Snapshot() ([]byte, error) {
+	panes, err := paneStates(op.panes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(timeSlidingWindowState{
+		NextWindowEnd:   op.nextWindowEnd,
+		MaxTime:         op.maxTime,
+		Panes:           panes,
+		StreamWatermark: op.streamWatermark,
+		WindowID:        op.ids.last,
+	})
+}
+
+func (op *TimeSlidingWindowOperator) Restore(data []byte) error {
+	var st timeSlidingWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	panes, err := restorePanes(op.agg, st.Panes)
+	if err != nil {
+		return err
+	}
+	op.nextWindowEnd, op.maxTime, op.panes = st.NextWindowEnd, st.MaxTime, panes
+	op.ids.restore(st.WindowID)
+	op.streamWatermark = st.StreamWatermark
+	return nil
+}
+
+type firingState struct {
+	ID      int           `json:"id"`
+	Results []model.Event `json:"results"`
+}
+
+type watermarkWindowState struct {
+	Windows         map[time.Time]paneState    `json:"windows"`
+	Fired           map[time.Time]firingState  `json:"fired"`
+	MaxEventTime    time.Time                  `json:"max_event_time"`
+	Watermark       time.Time                  `json:"watermark"`
+	StreamWatermark bool                       `json:"stream_watermark"`
+	Early           map[time.Time]*earlyFiring `json:"early,omitempty"`
+	WindowID        int                        `json:"window_id"`
+}
+
+func (op *TimeWindowWithWatermarkOperator) Snapshot() ([]byte, error) {
+	windows, err := paneStates(op.windows)
+	if err != nil {
+		return nil, err
+	}
+	fired := make(map[time.Time]firingState, len(op.fired))
+	for end, f := range op.fired {
+		fired[end] = firingState{ID: f.id, Results: f.results}
+	}
+	return json.Marshal(watermarkWindowState{
+		Windows:         windows,
+		Fired:           fired,
+		MaxEventTime:    op.maxEventTime,
+		Watermark:       op.watermark,
+		StreamWatermark: op.streamWatermark,
+		Early:           earlyFirings(op.trigger),
+		WindowID:        op.ids.last,
+	})
+}
+
+func (op *TimeWindowWithWatermarkOperator) Restore(data []byte) error {
+	var st watermarkWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	windows, err := restorePanes(op.agg, st.Windows)
+	if err != nil {
+		return err
+	}
+	op.windows = windows
+	op.fired = make(map[time.Time]*firing, len(st.Fired))
+	for end, f := range st.Fired {
+		op.fired[end] = &firing{id: f.ID, results: f.Results}
+	}
+	op.maxEventTime = st.MaxEventTime
+	op.watermark = st.Watermark
+	op.streamWatermark = st.StreamWatermark
+	op.ids.restore(st.WindowID)
+	restoreEarlyFirings(op.trigger, st.Early)
+	return nil
+}
+
+type sessionState struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Acc   paneState `json:"acc"`
+}
+
+type sessionWindowState struct {
+	Sessions        map[string][]sessionState `json:"sessions"`
+	MaxEventTime    time.Time                 `json:"max_event_time"`
+	Watermark       time.Time                 `json:"watermark"`
+	StreamWatermark bool                      `json:"stream_watermark"`
+	WindowID        int                       `json:"window_id"`
+}
+
+func (op *SessionWindowOperator) Snapshot() ([]byte, error) {
+	st := sessionWindowState{
+		Sessions:        make(map[string][]sessionState, len(op.sessions)),
+		MaxEventTime:    op.maxEventTime,
+		Watermark:       op.watermark,
+		StreamWatermark: op.streamWatermark,
+		WindowID:        op.windowID,
+	}
+	for k, sessions := range op.sessions {
+		for _, s := range sessions {
+			ps, err := s.acc.state()
+			if err != nil {
+				return nil, err
+			}
+			st.Sessions[k] = append(st.Sessions[k], sessionState{Start: s.start, End: s.end, Acc: ps})
+		}
+	}
+	return json.Marshal(st)
+}
+
+func (op *SessionWindowOperator) Restore(data []byte) error {
+	var st sessionWindowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	op.sessions = make(map[string][]*session, len(st.Sessions))
+	for k, states := range st.Sessions {
+		for _, ss := range states {
+			p, err := restorePane(op.agg, ss.Acc)
+			if err != nil {
+				return err
+			}
+			op.sessions[k] = append(op.sessions[k], &session{start: ss.Start, end: ss.End, acc: p})
+		}
+	}
+	op.maxEventTime, op.watermark, op.windowID = st.MaxEventTime, st.Watermark, st.WindowID
+	op.streamWatermark = st.StreamWatermark
+	return nil
+}
@@ -5,22 +5,39 @@ import (
     "time"
 )
 
+// TimeSlidingWindowOperator emits windows of windowSize every slide.
+// Events are pre-aggregated into panes of gcd(windowSize, slide), and panes
+// no future window can cover are dropped, so memory is bounded by
+// windowSize/pane accumulators rather than by the number of events.
+// Windows fire once the latest event time, or the pipeline's watermark if it
+// has one, passes their end. Events no open window covers are late: tagged
+// with late=true, or emitted on the LateOutput side output if lateOutput is
+// "side".
 type TimeSlidingWindowOperator struct {
-    name       string
-    windowSize time.Duration
-    slide      time.Duration
-    nextWindowEnd time.Time
-    events     []model.Event
-    inner      Operator // Should support ProcessBatch
-    windowID   int
+    name            string
+    windowSize      time.Duration
+    slide           time.Duration
+    lateOutput      string
+    paneDur         time.Duration
+    nextWindowEnd   time.Time
+    maxTime         time.Time
+    panes           map[time.Time]*pane // by pane start
+    streamWatermark bool                // fire from the pipeline's watermark
+    agg             Aggregator
+    inner           Operator // Should support ProcessBatch
+    ids             *windowIDs
 }
 
-func NewTimeSlidingWindowOperator(name string, windowSize, slide time.Duration, inner Operator) *TimeSlidingWindowOperator {
+func NewTimeSlidingWindowOperator(name string, windowSize, slide time.Duration, lateOutput string, inner Operator) *TimeSlidingWindowOperator {
     return &TimeSlidingWindowOperator{
         name:       name,
         windowSize: windowSize,
         slide:      slide,
-        events:     []model.Event{},
+        lateOutput: lateOutput,
+        ids:        &windowIDs{},
+        paneDur:    time.Duration(gcd(int64(windowSize), int64(slide))),
+        panes:      make(map[time.Time]*pane),
+        agg:        aggregatorFor(inner),
         inner:      inner,
     }
 }
@@ -28,84 +45,122 @@ func NewTimeSlidingWindowOperator(name string, windowSize, slide time.Duration,
 func (op *TimeSlidingWindowOperator) Name() string { return op.name }
 
 func (op *TimeSlidingWindowOperator) Process(event model.Event) []model.Event {
-    op.events = append(op.events, event)
-    out := []model.Event{}
-
     // On first event, initialize nextWindowEnd
     if op.nextWindowEnd.IsZero() {
         op.nextWindowEnd = event.Timestamp.Truncate(op.slide).Add(op.slide)
     }
+    if event.Timestamp.After(op.maxTime) {
+        op.maxTime = event.Timestamp
+    }
+    // Add the event to its pane, unless no future window covers it
+    start := event.Timestamp.Truncate(op.paneDur)
+    if !start.Add(op.paneDur).After(op.nextWindowEnd.Add(-op.windowSize)) {
+        return []model.Event{lateEvent(event, op.lateOutput)}
+    }
+    p, ok := op.panes[start]
+    if !ok {
+        p = newPane(op.agg)
+        op.panes[start] = p
+    }
+    p.add(event)
+
+    if op.streamWatermark {
+        return []model.Event{}
+    }
+    return op.advance(event.Timestamp)
+}
 
-    // While event.Timestamp >= nextWindowEnd, emit window and advance
-    for !event.Timestamp.Before(op.nextWindowEnd) {
-        // Emit window for [windowEnd - windowSize, windowEnd)
-        windowStart := op.nextWindowEnd.Add(-op.windowSize)
-        windowEvents := op.eventsInWindow(windowStart, op.nextWindowEnd)
-        if len(windowEvents) > 0 {
-            if batcher, ok := op.inner.(interface {
-                ProcessBatch([]model.Event) []model.Event
-            }); ok {
-                op.windowID++
-                windowResults := batcher.ProcessBatch(windowEvents)
-                for i := range windowResults {
-                    if windowResults[i].Data == nil {
-                        windowResults[i].Data = make(map[string]interface{})
-                    }
-                    windowResults[i].Data["window_end"] = op.nextWindowEnd.Format(time.RFC3339)
-                    windowResults[i].Data["window_id"] = op.windowID
-                }
-                out = append(out, windowResults...)
-            }
+func (op *TimeSlidingWindowOperator) OnWatermark(wm time.Time) []model.Event {
+    op.streamWatermark = true
+    if op.nextWindowEnd.IsZero() {
+        // Every window up to the watermark has closed, empty.
+        op.nextWindowEnd = wm.Truncate(op.slide).Add(op.slide)
+        return nil
+    }
+    return op.advance(wm)
+}
+
+// advance emits every window ending at or before t.
+func (op *TimeSlidingWindowOperator) advance(t time.Time) []model.Event {
+    out := []model.Event{}
+    // While t >= nextWindowEnd, emit window and advance
+    for !t.Before(op.nextWindowEnd) {
+        if len(op.panes) == 0 {
+            // Every window up to t is empty.
+            op.nextWindowEnd = t.Truncate(op.slide).Add(op.slide)
+            break
+        }
+        if op.skipEmpty(); t.Before(op.nextWindowEnd) {
+            break
         }
-        op.nextWindowEnd = op.nextWindowEnd.Add(op.slide)
+        out = append(out, op.emitWindow()...)
     }
     return out
 }
 
-// eventsInWindow returns events in [start, end)
-func (op *TimeSlidingWindowOperator) eventsInWindow(start, end time.Time) []model.Event {
-    res := []model.Event{}
-    for _, e := range op.events {
-        if !e.Timestamp.Before(start) && e.Timestamp.Before(end) {
-            res = append(res, e)
+// skipEmpty moves nextWindowEnd forward to the first window that covers a
+// pane, so a gap in event time costs one step rather than one per slide.
+func (op *TimeSlidingWindowOperator) skipEmpty() {
+    var first time.Time
+    for start := range op.panes {
+        if first.IsZero() || start.Before(first) {
+            first = start
+        }
+    }
+    if end := first.Truncate(op.slide).Add(op.slide); end.After(op.nextWindowEnd) {
+        op.nextWindowEnd = end
+    }
+}
+
+// emitWindow emits the window [nextWindowEnd - windowSize, nextWindowEnd),
+// advances to the next window and drops panes that are no longer needed.
+func (op *TimeSlidingWindowOperator) emitWindow() []model.Event {
+    end := op.nextWindowEnd
+    var panes []*pane
+    for start := end.Add(-op.windowSize); start.Before(end); start = start.Add(op.paneDur) {
+        if p, ok := op.panes[start]; ok {
+            panes = append(panes, p)
         }
     }
-    return res
+    op.nextWindowEnd = end.Add(op.slide)
+    horizon := op.nextWindowEnd.Add(-op.windowSize)
+    for start := range op.panes {
+        if start.Before(horizon) {
+            delete(op.panes, start)
+        }
+    }
+
+    if len(panes) == 0 {
+        return nil
+    }
+    id := op.ids.next()
+    windowResults := mergePanes(op.agg, panes).acc.Result()
+    for i := range windowResults {
+        if windowResults[i].Data == nil {
+            windowResults[i].Data = make(map[string]interface{})
+        }
+        windowResults[i].Data["window_end"] = end.Format(time.RFC3339)
+        windowResults[i].Data["window_id"] = id
+        windowResults[i].Timestamp = windowTime(end)
+    }
+    return windowResults
 }
 
 // Call at end of input to flush remaining windows
 func (op *TimeSlidingWindowOperator) Flush() []model.Event {
     out := []model.Event{}
-    if len(op.events) == 0 || op.nextWindowEnd.IsZero() {
+    if len(op.panes) == 0 || op.nextWindowEnd.IsZero() {
         return out
     }
-    // Find the max timestamp to know where to stop
-    maxT := op.events[0].Timestamp
-    for _, e := range op.events {
-        if e.Timestamp.After(maxT) {
-            maxT = e.Timestamp
-        }
-    }
-    for !op.nextWindowEnd.After(maxT.Add(op.windowSize)) {
-        windowStart := op.nextWindowEnd.Add(-op.windowSize)
-        windowEvents := op.eventsInWindow(windowStart, op.nextWindowEnd)
-        if len(windowEvents) > 0 {
-            if batcher, ok := op.inner.(interface {
-                ProcessBatch([]model.Event) []model.Event
-            }); ok {
-                op.windowID++
-                windowResults := batcher.ProcessBatch(windowEvents)
-                for i := range windowResults {
-                    if windowResults[i].Data == nil {
-                        windowResults[i].Data = make(map[string]interface{})
-                    }
-                    windowResults[i].Data["window_end"] = op.nextWindowEnd.Format(time.RFC3339)
-                    windowResults[i].Data["window_id"] = op.windowID
-                }
-                out = append(out, windowResults...)
-            }
-        }
-        op.nextWindowEnd = op.nextWindowEnd.Add(op.slide)
+    for len(op.panes) > 0 {
+        op.skipEmpty()
+        out = append(out, op.emitWindow()...)
     }
     return out
 }
+
+// idle reports whether no pane is open and the pipeline's watermark decides
+// when windows close.
+func (op *TimeSlidingWindowOperator) idle() bool { return op.streamWatermark && len(op.panes) == 0 }
+
+func (op *TimeSlidingWindowOperator) useIDs(ids *windowIDs) { op.ids = ids }
@@ -2,29 +2,60 @@ package operator
 
 import (
     "goxstream/internal/model"
+    "sort"
     "time"
 )
 
+// LateOutput is the side output that receives events arriving after their
+// window's allowed lateness has expired.
+const LateOutput = "late"
+
+// TimeWindowWithWatermarkOperator is a tumbling event-time window. The
+// watermark trails the max event time by outOfOrderness, unless the pipeline
+// provides one; a window fires once the watermark passes its end and keeps
+// its state for another allowedLateness. An event arriving for a fired
+// window within that time re-fires it: the previous results are retracted
+// (re-emitted with retract=true) and the updated results follow under the
+// same window_id. Events later than that are tagged with late=true on the
+// main output, or emitted on the LateOutput side output if lateOutput is
+// "side". A trigger adds early firings; with a discarding trigger, late
+// firings cover only the late events and retract nothing.
 type TimeWindowWithWatermarkOperator struct {
     name            string
     windowDur       time.Duration
+    outOfOrderness  time.Duration
     allowedLateness time.Duration
-    windows         map[time.Time][]model.Event
+    lateOutput      string
+    streamWatermark bool                  // use the pipeline's watermark
+    trigger         *triggerState         // nil: no early firings
+    windows         map[time.Time]*pane   // window state by end time
+    fired           map[time.Time]*firing // windows already emitted, until their lateness expires
     maxEventTime    time.Time
     watermark       time.Time
+    agg             Aggregator
     inner           Operator
-    emittedWindows  map[time.Time]bool
-    windowID        int
+    ids             *windowIDs
+}
+
+// firing remembers what a window emitted so it can be retracted.
+type firing struct {
+    id      int
+    results []model.Event
 }
 
-func NewTimeWindowWithWatermarkOperator(name string, windowDur, allowedLateness time.Duration, inner Operator) *TimeWindowWithWatermarkOperator {
+func NewTimeWindowWithWatermarkOperator(name string, windowDur, outOfOrderness, allowedLateness time.Duration, lateOutput string, trigger *Trigger, inner Operator) *TimeWindowWithWatermarkOperator {
     return &TimeWindowWithWatermarkOperator{
         name:            name,
         windowDur:       windowDur,
+        outOfOrderness:  outOfOrderness,
         allowedLateness: allowedLateness,
-        windows:         make(map[time.Time][]model.Event),
+        lateOutput:      lateOutput,
+        trigger:         newTriggerState(trigger),
+        windows:         make(map[time.Time]*pane),
+        fired:           make(map[time.Time]*firing),
+        agg:             aggregatorFor(inner),
         inner:           inner,
-        emittedWindows:  make(map[time.Time]bool),
+        ids:             &windowIDs{},
     }
 }
 
@@ -34,125 +65,397 @@ func (op *TimeWindowWithWatermarkOperator) Process(event model.Event) []model.Ev
     ts := event.Timestamp
     if ts.After(op.maxEventTime) {
         op.maxEventTime = ts
-        op.watermark = op.maxEventTime.Add(-op.allowedLateness)
+        if !op.streamWatermark {
+            op.watermark = op.maxEventTime.Add(-op.outOfOrderness)
+        }
     }
-    // Assign event to its window
+
+    out := []model.Event{}
     windowEnd := ts.Truncate(op.windowDur).Add(op.windowDur)
-    op.windows[windowEnd] = append(op.windows[windowEnd], event)
+    if !windowEnd.Add(op.allowedLateness).After(op.watermark) {
+        // Too late: the window's state is gone.
+        out = append(out, lateEvent(event, op.lateOutput))
+    } else {
+        w, ok := op.windows[windowEnd]
+        if !ok {
+            w = newPane(op.agg)
+            op.windows[windowEnd] = w
+        }
+        w.add(event)
+        if f, ok := op.fired[windowEnd]; ok {
+            out = append(out, op.refire(windowEnd, f)...)
+        } else if op.trigger != nil && op.trigger.countDue(windowEnd, w) {
+            out = append(out, op.fireEarly(windowEnd)...)
+        }
+    }
+    return append(out, op.advance()...)
+}
 
+// OnTimer fires the open windows that changed since they last fired, once
+// every trigger interval.
+func (op *TimeWindowWithWatermarkOperator) OnTimer(now time.Time) []model.Event {
     out := []model.Event{}
-    // Emit any windows whose end <= watermark (and not yet emitted)
-    for end := range op.windows {
-        if (end.Before(op.watermark) || end.Equal(op.watermark)) && !op.emittedWindows[end] {
-            op.windowID++
-            results := op.emitWindow(end)
-            // annotate
-            for i := range results {
-                if results[i].Data == nil {
-                    results[i].Data = make(map[string]interface{})
-                }
-                results[i].Data["window_end"] = end.Format(time.RFC3339)
-                results[i].Data["window_id"] = op.windowID
-            }
-            out = append(out, results...)
-            op.emittedWindows[end] = true
+    if op.trigger == nil || !op.trigger.tick(now) {
+        return out
+    }
+    for _, end := range op.pendingEnds() {
+        if op.trigger.changed(end, op.windows[end]) {
+            out = append(out, op.fireEarly(end)...)
         }
     }
     return out
 }
 
-func (op *TimeWindowWithWatermarkOperator) emitWindow(windowEnd time.Time) []model.Event {
-    events := op.windows[windowEnd]
-    if batcher, ok := op.inner.(interface {
-        ProcessBatch([]model.Event) []model.Event
-    }); ok && len(events) > 0 {
-        return batcher.ProcessBatch(events)
+// OnWatermark replaces the operator's own watermark with the pipeline's.
+func (op *TimeWindowWithWatermarkOperator) OnWatermark(wm time.Time) []model.Event {
+    op.streamWatermark = true
+    if wm.After(op.watermark) {
+        op.watermark = wm
     }
-    return nil
+    return op.advance()
 }
 
-func (op *TimeWindowWithWatermarkOperator) Flush() []model.Event {
+// advance fires the windows the watermark has passed and forgets those whose
+// allowed lateness has expired.
+func (op *TimeWindowWithWatermarkOperator) advance() []model.Event {
     out := []model.Event{}
+    // Fire any windows whose end <= watermark, oldest first
+    for _, end := range op.pendingEnds() {
+        if !end.After(op.watermark) {
+            out = append(out, op.fire(end, false)...)
+        }
+    }
+    // Forget fired windows whose allowed lateness has expired
+    for end := range op.fired {
+        if !end.Add(op.allowedLateness).After(op.watermark) {
+            delete(op.fired, end)
+            delete(op.windows, end)
+        }
+    }
+    return out
+}
+
+// lateEvent marks an event too late for its window: tagged with late=true
+// on the main output if lateOutput is "tag", or sent to the LateOutput side
+// output if it is "side".
+func lateEvent(event model.Event, lateOutput string) model.Event {
+    if lateOutput == "tag" {
+        e := event.Clone()
+        if e.Data == nil {
+            e.Data = make(map[string]interface{})
+        }
+        e.Data["late"] = true
+        return e
+    }
+    event.Output = LateOutput
+    return event
+}
+
+// pendingEnds returns the end times of the windows not fired yet, in order.
+func (op *TimeWindowWithWatermarkOperator) pendingEnds() []time.Time {
+    ends := make([]time.Time, 0, len(op.windows))
     for end := range op.windows {
-        if !op.emittedWindows[end] {
-            op.windowID++
-            results := op.emitWindow(end)
-            for i := range results {
-                if results[i].Data == nil {
-                    results[i].Data = make(map[string]interface{})
-                }
-                results[i].Data["window_end"] = end.Format(time.RFC3339)
-                results[i].Data["window_id"] = op.windowID
-                results[i].Data["emitted_via_flush"] = true
-            }
-            out = append(out, results...)
-            op.emittedWindows[end] = true
+        if _, fired := op.fired[end]; !fired {
+            ends = append(ends, end)
+        }
+    }
+    sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+    return ends
+}
+
+// results computes and annotates the current result of a window.
+func (op *TimeWindowWithWatermarkOperator) results(windowEnd time.Time, id int, timing string) []model.Event {
+    results := op.windows[windowEnd].acc.Result()
+    // annotate
+    for i := range results {
+        if results[i].Data == nil {
+            results[i].Data = make(map[string]interface{})
+        }
+        results[i].Data["window_end"] = windowEnd.Format(time.RFC3339)
+        results[i].Data["window_id"] = id
+        results[i].Timestamp = windowTime(windowEnd)
+    }
+    op.trigger.setTiming(results, timing)
+    return results
+}
+
+func (op *TimeWindowWithWatermarkOperator) discarding() bool {
+    return op.trigger != nil && op.trigger.Discarding
+}
+
+func (op *TimeWindowWithWatermarkOperator) fireEarly(windowEnd time.Time) []model.Event {
+    id := op.trigger.fireEarly(windowEnd, op.windows[windowEnd], op.ids.next)
+    results := op.results(windowEnd, id, "early")
+    if op.discarding() {
+        op.windows[windowEnd] = newPane(op.agg)
+    }
+    return results
+}
+
+func (op *TimeWindowWithWatermarkOperator) fire(windowEnd time.Time, flush bool) []model.Event {
+    var id int
+    if op.trigger != nil {
+        id = op.trigger.close(windowEnd)
+    }
+    if id == 0 {
+        id = op.ids.next()
+    }
+    var results []model.Event
+    if op.windows[windowEnd].n > 0 {
+        results = op.results(windowEnd, id, "on_time")
+    }
+    if flush {
+        for i := range results {
+            results[i].Data["emitted_via_flush"] = true
         }
     }
+    f := &firing{id: id}
+    if op.discarding() {
+        op.windows[windowEnd] = newPane(op.agg)
+    } else {
+        f.results = cloneEvents(results)
+    }
+    op.fired[windowEnd] = f
+    return results
+}
+
+// refire retracts a fired window's previous results and emits its updated
+// ones. With a discarding trigger it emits the result of the late events
+// alone.
+func (op *TimeWindowWithWatermarkOperator) refire(windowEnd time.Time, f *firing) []model.Event {
+    if op.discarding() {
+        results := op.results(windowEnd, f.id, "late")
+        op.windows[windowEnd] = newPane(op.agg)
+        return results
+    }
+    out := cloneEvents(f.results)
+    for i := range out {
+        out[i].Data["retract"] = true
+    }
+    results := op.results(windowEnd, f.id, "late")
+    f.results = cloneEvents(results)
+    return append(out, results...)
+}
+
+func (op *TimeWindowWithWatermarkOperator) Flush() []model.Event {
+    out := []model.Event{}
+    for _, end := range op.pendingEnds() {
+        out = append(out, op.fire(end, true)...)
+    }
+    op.windows = make(map[time.Time]*pane)
+    op.fired = make(map[time.Time]*firing)
+    return out
+}
+
+// idle reports whether no window is open or awaiting late events, and the
+// pipeline's watermark, rather than the operator's own, decides lateness.
+func (op *TimeWindowWithWatermarkOperator) idle() bool {
+    return op.streamWatermark && len(op.windows) == 0 && len(op.fired) == 0
+}
+
+func (op *TimeWindowWithWatermarkOperator) useIDs(ids *windowIDs) { op.ids = ids }
+
+// cloneEvents copies results so downstream operators cannot modify the
+// copies kept for retraction.
+func cloneEvents(events []model.Event) []model.Event {
+    out := make([]model.Event, len(events))
+    for i, e := range events {
+        out[i] = e.Clone()
+    }
     return out
 }
 
 
 // -------------------- Basic Time-based Tumbling Window (no watermark) --------------------
 
+// TimeWindowOperator is a tumbling event-time window without lateness
+// handling. Windows fire once time passes their end: the latest event time,
+// or the pipeline's watermark if it has one. With the pipeline's watermark,
+// events for a window that has already fired are late: tagged with
+// late=true, or emitted on the LateOutput side output if lateOutput is
+// "side". Without it, they join the window still open. A trigger adds early
+// firings.
 type TimeWindowOperator struct {
-    name      string
-    windowDur time.Duration
-    windowEnd time.Time
-    buffer    []model.Event
-    inner     Operator
-    windowID  int
+    name            string
+    windowDur       time.Duration
+    lateOutput      string
+    windowEnd       time.Time           // end of the oldest window not fired yet
+    windows         map[time.Time]*pane // accumulated state of the open windows, by end time
+    streamWatermark bool                // fire from the pipeline's watermark
+    trigger         *triggerState       // nil: fire only when windows close
+    agg             Aggregator
+    inner           Operator
+    ids             *windowIDs
 }
 
-func NewTimeWindowOperator(name string, windowDur time.Duration, inner Operator) *TimeWindowOperator {
+func NewTimeWindowOperator(name string, windowDur time.Duration, lateOutput string, trigger *Trigger, inner Operator) *TimeWindowOperator {
     return &TimeWindowOperator{
-        name:      name,
-        windowDur: windowDur,
-        buffer:    []model.Event{},
-        inner:     inner,
+        name:       name,
+        windowDur:  windowDur,
+        lateOutput: lateOutput,
+        windows:    make(map[time.Time]*pane),
+        trigger:    newTriggerState(trigger),
+        agg:        aggregatorFor(inner),
+        inner:      inner,
+        ids:        &windowIDs{},
     }
 }
 
 func (op *TimeWindowOperator) Name() string { return op.name }
 
 func (op *TimeWindowOperator) Process(event model.Event) []model.Event {
+    end := event.Timestamp.Truncate(op.windowDur).Add(op.windowDur)
     if op.windowEnd.IsZero() {
-        op.windowEnd = event.Timestamp.Truncate(op.windowDur).Add(op.windowDur)
+        op.windowEnd = end
+    }
+    if end.Before(op.windowEnd) {
+        if op.streamWatermark {
+            return []model.Event{lateEvent(event, op.lateOutput)}
+        }
+        // Without pipeline watermarks, an out-of-order event joins the
+        // open window.
+        end = op.windowEnd
     }
 
     out := []model.Event{}
-    // If event is after windowEnd, emit and start new window(s)
-    for !event.Timestamp.Before(op.windowEnd) {
-        out = append(out, op.emitWindow()...)
-        op.windowEnd = op.windowEnd.Add(op.windowDur)
+    if !op.streamWatermark {
+        // If event is after windowEnd, emit and start new window(s)
+        out = op.advance(event.Timestamp)
+    }
+    w, ok := op.windows[end]
+    if !ok {
+        w = newPane(op.agg)
+        op.windows[end] = w
+    }
+    w.add(event)
+    if op.trigger != nil && op.trigger.countDue(end, w) {
+        out = append(out, op.fireEarly(end)...)
     }
-    op.buffer = append(op.buffer, event)
     return out
 }
 
-func (op *TimeWindowOperator) emitWindow() []model.Event {
+func (op *TimeWindowOperator) OnWatermark(wm time.Time) []model.Event {
+    op.streamWatermark = true
+    if op.windowEnd.IsZero() {
+        // Every window up to the watermark has closed, empty.
+        op.windowEnd = wm.Truncate(op.windowDur).Add(op.windowDur)
+        return nil
+    }
+    return op.advance(wm)
+}
+
+// OnTimer fires the windows that changed since they last fired, once every
+// trigger interval.
+func (op *TimeWindowOperator) OnTimer(now time.Time) []model.Event {
     out := []model.Event{}
-    if batcher, ok := op.inner.(interface {
-        ProcessBatch([]model.Event) []model.Event
-    }); ok && len(op.buffer) > 0 {
-        op.windowID++
-        result := batcher.ProcessBatch(op.buffer)
-        for i := range result {
-            if result[i].Data == nil {
-                result[i].Data = make(map[string]interface{})
-            }
-            result[i].Data["window_end"] = op.windowEnd.Format(time.RFC3339)
-            result[i].Data["window_id"] = op.windowID
+    if op.trigger == nil || !op.trigger.tick(now) {
+        return out
+    }
+    ends := make([]time.Time, 0, len(op.windows))
+    for end, w := range op.windows {
+        if op.trigger.changed(end, w) {
+            ends = append(ends, end)
         }
-        out = append(out, result...)
     }
-    op.buffer = nil
+    sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+    for _, end := range ends {
+        out = append(out, op.fireEarly(end)...)
+    }
     return out
 }
 
-func (op *TimeWindowOperator) Flush() []model.Event {
-    if len(op.buffer) == 0 {
+// advance fires every window ending at or before t, in order.
+func (op *TimeWindowOperator) advance(t time.Time) []model.Event {
+    out := []model.Event{}
+    if op.windowEnd.IsZero() || t.Before(op.windowEnd) {
+        return out
+    }
+    for _, end := range op.openEnds() {
+        if end.After(t) {
+            break
+        }
+        out = append(out, op.emitWindow(end)...)
+    }
+    // Every window up to t has closed.
+    op.windowEnd = t.Truncate(op.windowDur).Add(op.windowDur)
+    return out
+}
+
+// openEnds returns the end times of the open windows, in order.
+func (op *TimeWindowOperator) openEnds() []time.Time {
+    ends := make([]time.Time, 0, len(op.windows))
+    for end := range op.windows {
+        ends = append(ends, end)
+    }
+    sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+    return ends
+}
+
+func (op *TimeWindowOperator) fireEarly(end time.Time) []model.Event {
+    w := op.windows[end]
+    id := op.trigger.fireEarly(end, w, op.ids.next)
+    if op.trigger.Discarding {
+        op.windows[end] = newPane(op.agg)
+    }
+    return op.results(w, end, id, "early")
+}
+
+// emitWindow fires the window ending at end and forgets it.
+func (op *TimeWindowOperator) emitWindow(end time.Time) []model.Event {
+    var id int
+    if op.trigger != nil {
+        id = op.trigger.close(end)
+    }
+    w, ok := op.windows[end]
+    if !ok {
+        return nil
+    }
+    delete(op.windows, end)
+    if w.n == 0 {
+        // Discarding trigger, and nothing arrived since the last firing.
         return nil
     }
-    return op.emitWindow()
+    if id == 0 {
+        id = op.ids.next()
+    }
+    return op.results(w, end, id, "on_time")
+}
+
+// results computes and annotates the result of window w.
+func (op *TimeWindowOperator) results(w *pane, end time.Time, id int, timing string) []model.Event {
+    result := w.acc.Result()
+    for i := range result {
+        if result[i].Data == nil {
+            result[i].Data = make(map[string]interface{})
+        }
+        result[i].Data["window_end"] = end.Format(time.RFC3339)
+        result[i].Data["window_id"] = id
+        result[i].Timestamp = windowTime(end)
+    }
+    op.trigger.setTiming(result, timing)
+    return result
 }
+
+// Flush fires every open window, in order.
+func (op *TimeWindowOperator) Flush() []model.Event {
+    out := []model.Event{}
+    ends := op.openEnds()
+    for _, end := range ends {
+        out = append(out, op.emitWindow(end)...)
+    }
+    if len(ends) > 0 {
+        op.windowEnd = ends[len(ends)-1].Add(op.windowDur)
+    }
+    return out
+}
+
+// idle reports whether no window is open and the pipeline's watermark
+// decides when windows close.
+func (op *TimeWindowOperator) idle() bool { return op.streamWatermark && len(op.windows) == 0 }
+
+func (op *TimeWindowOperator) useIDs(ids *windowIDs) { op.ids = ids }
+
+// windowTime is the event time given to a window's results: the last instant
+// the window covers, so a downstream time window puts them in the window
+// that contains this one.
+func windowTime(end time.Time) time.Time { return end.Add(-time.Nanosecond) }
@@ -0,0 +1,108 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// base is the event time the operator tests count seconds from.
+var base = time.Date(2025, 7, 5, 21, 0, 0, 0, time.UTC)
+
+func at(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+
+func record(sec int, data map[string]interface{}) model.Event {
+	return model.Event{Timestamp: at(sec), Data: data}
+}
+
+// build builds an operator from its spec, failing the test on error.
+func build(t *testing.T, typ string, params map[string]interface{}) Operator {
+	t.Helper()
+	op, err := BuildOperator(model.OperatorSpec{Type: typ, Params: params})
+	if err != nil {
+		t.Fatalf("build %s: %v", typ, err)
+	}
+	return op
+}
+
+// countBy is the params of a reduce counting the events per value of field.
+func countBy(field string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "reduce",
+		"params": map[string]interface{}{"key": field, "agg": "count", "field": field},
+	}
+}
+
+// windowEnds lists the window_end of each result, failing on any that is
+// not a window result.
+func windowEnds(t *testing.T, events []model.Event) []string {
+	t.Helper()
+	var ends []string
+	for _, e := range events {
+		end, ok := e.Data["window_end"].(string)
+		if !ok {
+			t.Fatalf("not a window result: %v", e.Data)
+		}
+		ends = append(ends, end)
+	}
+	return ends
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// An event far ahead of the others must not make the windows step through
+// every empty window in between.
+func TestTimeWindowsSkipEmptyWindows(t *testing.T) {
+	outlier := 100 * 365 * 24 * 3600 // a century ahead
+	tests := []struct {
+		typ    string
+		params map[string]interface{}
+		want   []string
+	}{
+		{
+			typ:    "time_window",
+			params: map[string]interface{}{"duration": "1s", "inner": countBy("k")},
+			want:   []string{at(1).Format(time.RFC3339), at(outlier + 1).Format(time.RFC3339)},
+		},
+		{
+			typ:    "time_sliding_window",
+			params: map[string]interface{}{"size": "2s", "slide": "1s", "inner": countBy("k")},
+			want: []string{
+				at(1).Format(time.RFC3339), at(2).Format(time.RFC3339),
+				at(outlier + 1).Format(time.RFC3339), at(outlier + 2).Format(time.RFC3339),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			for _, withWatermark := range []bool{false, true} {
+				op := build(t, tt.typ, tt.params)
+				h := op.(WatermarkHandler)
+				var out []model.Event
+				if withWatermark {
+					h.OnWatermark(at(0))
+				}
+				out = append(out, op.Process(record(0, map[string]interface{}{"k": "a"}))...)
+				out = append(out, op.Process(record(outlier, map[string]interface{}{"k": "a"}))...)
+				if withWatermark {
+					out = append(out, h.OnWatermark(at(outlier-1))...)
+				}
+				out = append(out, op.(Flusher).Flush()...)
+				if got := windowEnds(t, out); !equalStrings(got, tt.want) {
+					t.Errorf("watermark %v: window ends = %v, want %v", withWatermark, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// Trigger adds early firings to a tumbling time window on top of the firing
+// when the window closes: every Count events added to a window, and every
+// Interval of processing time for the windows that received events since
+// they last fired. In accumulating mode each firing covers the whole window
+// so far; with Discarding the window's state is cleared whenever it fires,
+// so each firing covers only the events since the previous one. Results of
+// a triggered window carry a "timing" of early, on_time or late.
+type Trigger struct {
+	Count      int
+	Interval   time.Duration
+	Discarding bool
+}
+
+// parseTrigger reads the "trigger" param of a window, e.g.
+// { "count": 100, "interval": "1m", "mode": "discarding" }. It returns nil if
+// there is none.
+func parseTrigger(v interface{}) (*Trigger, error) {
+	if v == nil {
+		return nil, nil
+	}
+	params, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("trigger must be an object")
+	}
+	t := &Trigger{}
+	if c, ok := params["count"]; ok {
+		n, ok := c.(float64)
+		if !ok || n < 1 || n != float64(int(n)) {
+			return nil, fmt.Errorf("trigger count must be a positive integer")
+		}
+		t.Count = int(n)
+	}
+	if s, ok := params["interval"].(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid trigger interval: %q", s)
+		}
+		t.Interval = d
+	}
+	mode, _ := params["mode"].(string)
+	switch mode {
+	case "", "accumulating":
+	case "discarding":
+		t.Discarding = true
+	default:
+		return nil, fmt.Errorf("trigger mode must be accumulating or discarding, got %q", mode)
+	}
+	return t, nil
+}
+
+// triggerState tracks the early firings of a window operator's open windows.
+type triggerState struct {
+	*Trigger
+	early    map[time.Time]*earlyFiring // windows that fired early, by end time
+	lastTick time.Time
+}
+
+type earlyFiring struct {
+	ID int `json:"id"` // window_id of all the window's firings
+	N  int `json:"n"`  // events in the window at its last firing
+}
+
+func newTriggerState(t *Trigger) *triggerState {
+	if t == nil {
+		return nil
+	}
+	return &triggerState{Trigger: t, early: make(map[time.Time]*earlyFiring), lastTick: time.Now()}
+}
+
+// firedN is the number of window events the last firing already covered.
+func (t *triggerState) firedN(end time.Time) int {
+	if f, ok := t.early[end]; ok && !t.Discarding {
+		return f.N
+	}
+	return 0
+}
+
+// changed reports whether window w received events since it last fired.
+func (t *triggerState) changed(end time.Time, w *pane) bool { return w.n > t.firedN(end) }
+
+// countDue reports whether window w has collected Count events since it last
+// fired.
+func (t *triggerState) countDue(end time.Time, w *pane) bool {
+	return t.Count > 0 && w.n-t.firedN(end) >= t.Count
+}
+
+// tick reports whether the processing-time interval has elapsed at now, and
+// restarts it.
+func (t *triggerState) tick(now time.Time) bool {
+	if t.Interval == 0 || now.Sub(t.lastTick) < t.Interval {
+		return false
+	}
+	t.lastTick = now
+	return true
+}
+
+// fireEarly records an early firing of the window ending at end and returns
+// its window ID, taking a new one from next on its first firing.
+func (t *triggerState) fireEarly(end time.Time, w *pane, next func() int) int {
+	f, ok := t.early[end]
+	if !ok {
+		f = &earlyFiring{ID: next()}
+		t.early[end] = f
+	}
+	f.N = w.n
+	return f.ID
+}
+
+// close forgets the early firings of the window ending at end and returns
+// its window ID, or 0 if it never fired early.
+func (t *triggerState) close(end time.Time) int {
+	f, ok := t.early[end]
+	if !ok {
+		return 0
+	}
+	delete(t.early, end)
+	return f.ID
+}
+
+// setTiming annotates the results of a triggered window with their timing.
+func (t *triggerState) setTiming(results []model.Event, timing string) {
+	if t == nil {
+		return
+	}
+	for i := range results {
+		results[i].Data["timing"] = timing
+	}
+}
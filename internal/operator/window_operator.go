@@ -4,28 +4,68 @@ import (
     "goxstream/internal/model"
 )
 
+// windowIDs numbers the windows an operator fires. The windows of a keyed
+// operator share one, so a window_id is unique across keys and is not
+// reused when a key's window is dropped and created again.
+type windowIDs struct {
+    last int
+}
+
+func (ids *windowIDs) next() int {
+    ids.last++
+    return ids.last
+}
+
+// restore continues the sequence after id, unless it is already past it.
+func (ids *windowIDs) restore(id int) {
+    if id > ids.last {
+        ids.last = id
+    }
+}
+
+// sequenced is implemented by windows that number what they fire, so a keyed
+// operator can have the windows of every key draw from one sequence.
+type sequenced interface {
+    useIDs(ids *windowIDs)
+}
+
 type TumblingWindowOperator struct {
-    name  string
-    size  int
-	buffer []model.Event
-    inner Operator // e.g., a reduce operator
+    name   string
+    size   int
+    window *pane // accumulated state of the open window
+    agg    Aggregator
+    inner  Operator // e.g., a reduce operator
 }
 
 func NewTumblingWindowOperator(name string, size int, inner Operator) *TumblingWindowOperator {
-    return &TumblingWindowOperator{name: name, size: size, inner: inner}
+    return &TumblingWindowOperator{name: name, size: size, inner: inner, agg: aggregatorFor(inner)}
 }
 
 func (op *TumblingWindowOperator) Name() string { return op.name }
 
 // Processes one event at a time, but emits only when window is full
 func (op *TumblingWindowOperator) Process(event model.Event) []model.Event {
-    op.buffer = append(op.buffer, event)
-    if len(op.buffer) >= op.size {
-        out := op.inner.(interface {
-            ProcessBatch([]model.Event) []model.Event
-        }).ProcessBatch(op.buffer)
-        op.buffer = nil
+    if op.window == nil {
+        op.window = newPane(op.agg)
+    }
+    op.window.add(event)
+    if op.window.n >= op.size {
+        out := op.window.acc.Result()
+        op.window = nil
         return out
     }
     return nil
-}
\ No newline at end of file
+}
+
+// Flush emits the partial window left over at end of input.
+func (op *TumblingWindowOperator) Flush() []model.Event {
+    if op.window == nil {
+        return nil
+    }
+    out := op.window.acc.Result()
+    op.window = nil
+    return out
+}
+
+// idle reports whether no window is open.
+func (op *TumblingWindowOperator) idle() bool { return op.window == nil }
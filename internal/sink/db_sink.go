@@ -13,14 +13,25 @@ type DBSinkConfig struct {
     Table string
 }
 
-func DBSink(cfg DBSinkConfig, in <-chan model.Event) error {
+// DBSink inserts each record as it arrives, so a barrier is acknowledged as
+// soon as it is received.
+func DBSink(ctx context.Context, cfg DBSinkConfig, in <-chan model.Event, ack func(checkpoint int64)) error {
     db, err := sql.Open("postgres", cfg.DSN)
     if err != nil { return fmt.Errorf("open db: %w", err) }
     defer db.Close()
 
+    ctx, cancel := drainContext(ctx)
+    defer cancel()
+
     for event := range in {
+        if !event.IsRecord() {
+            if event.Kind == model.KindBarrier {
+                ack(event.Checkpoint)
+            }
+            continue
+        }
         // For simplicity, write only JSON-encoded Data
-        _, err := db.ExecContext(context.Background(),
+        _, err := db.ExecContext(ctx,
             fmt.Sprintf("INSERT INTO %s (data) VALUES ($1)", cfg.Table),
             event.Data)
         if err != nil {
@@ -1,48 +1,813 @@
 package sink
 
 import (
+    "bufio"
     "encoding/csv"
+    "encoding/json"
+    "io"
     "os"
+    "path/filepath"
+    "regexp"
+    "goxstream/internal/compression"
     "goxstream/internal/model"
     "sort"
+    "strconv"
+    "strings"
+    "time"
 	"fmt"
 )
 
-func FileSink(path string, in <-chan model.Event) error {
-    f, err := os.Create(path)
+// FileSinkConfig sets the file to write.
+type FileSinkConfig struct {
+    Path string
+    // Compression is the codec of the file (see compression.Detect); by
+    // default its extension decides.
+    Compression string
+    Format      string   // csv (default) or jsonl
+    Columns     []string // columns, in order; by default every field seen
+    OnDrift     string   // report (default) or fail on fields outside the columns
+
+    // With any of the settings below, the sink writes a series of files
+    // named after Path (see rollingName): one open file per time bucket,
+    // rolled over to a new one whenever a size or record limit is reached.
+    // A file is written under a hidden temp name and only renamed once
+    // complete, so readers never see a partial file; if the sink fails, its
+    // open temp file is removed instead, and the temp files of a run that
+    // crashed are completed or removed when the job resumes.
+    MaxBytes   int64         // bytes of uncompressed output per file
+    MaxRecords int64         // records per file
+    Bucket     time.Duration // one file per bucket of event time, e.g. an hour
+}
+
+func (cfg FileSinkConfig) rolling() bool {
+    return cfg.MaxBytes > 0 || cfg.MaxRecords > 0 || cfg.Bucket > 0
+}
+
+// spooled reports whether the sink writes a single CSV file whose columns
+// are only known once the input ends.
+func (cfg FileSinkConfig) spooled() bool {
+    return !cfg.rolling() && cfg.Format != "jsonl" && cfg.Columns == nil
+}
+
+// FileSink writes events as CSV or JSON Lines. JSON records keep all their
+// fields unless Columns is set. CSV columns are Columns or, by default,
+// every field seen: a single CSV file is spooled until the input ends, and
+// then written with a column for every field of any event (see openSpool),
+// while each new file of a rolling sink has the union of the fields seen
+// until it starts. Fields outside the columns are dropped and counted in
+// counters, or fail the sink before the record is written, depending on
+// OnDrift.
+//
+// At a checkpoint barrier, everything written so far is flushed and synced
+// to disk, ending the current compressed stream, and the barrier is
+// acknowledged with the size of every open file. A sink resuming from that
+// checkpoint (state) truncates the single file, or its spool, back to its
+// size and appends to it, so the records the job processes again are not
+// written twice. A rolling sink instead completes the files that were in
+// progress at the checkpoint, truncated to their size, and removes the
+// in-progress files a failed run started after it (see recoverInProgress).
+func FileSink(cfg FileSinkConfig, state json.RawMessage, in <-chan model.Event, ack Ack, counters *Counters) (err error) {
+    codec, err := compression.Detect(cfg.Path, cfg.Compression)
     if err != nil {
         return err
     }
-    defer f.Close()
-    writer := csv.NewWriter(f)
-    var headers []string
-    var headersWritten bool
+    var restored fileSinkState
+    if state != nil {
+        if err := json.Unmarshal(state, &restored); err != nil {
+            return fmt.Errorf("file sink state: %w", err)
+        }
+    }
+    files := &fileSet{
+        cfg:   cfg,
+        codec: codec,
+        open:  make(map[time.Time]*fileWriter),
+        seq:   make(map[time.Time]int),
+        seen:  make(map[string]bool),
+        spool: cfg.spooled(),
+    }
+    defer func() {
+        if ferr := files.finish(err); err == nil {
+            err = ferr
+        }
+    }()
+    var single *fileWriter
+    switch {
+    case cfg.rolling():
+        err = recoverInProgress(cfg, restored.Files, codec)
+    case files.spool:
+        single, err = openSpool(cfg, restored.Files, codec, files.seen)
+    case len(restored.Files) == 1 && restored.Files[0].Path == cfg.Path:
+        single, err = resumeFileWriter(restored.Files[0], codec, cfg.Format)
+    default:
+        single, err = openFileWriter(cfg.Path, "", codec, cfg.Format)
+    }
+    if err != nil {
+        return err
+    }
+    if single != nil {
+        files.open[time.Time{}] = single
+    }
+
+    counters.Add("dropped_values", 0)
     for event := range in {
-        if !headersWritten {
-            // Consistent header order (sort for now; you can use custom order if needed)
-            for k := range event.Data {
-                headers = append(headers, k)
+        switch event.Kind {
+        case model.KindBarrier:
+            state, err := files.checkpoint()
+            if err != nil {
+                return err
+            }
+            ack(event.Checkpoint, state)
+            continue
+        case model.KindWatermark:
+            if err := files.watermark(event.Timestamp); err != nil {
+                return err
+            }
+            continue
+        }
+        for k := range event.Data {
+            files.seen[k] = true
+        }
+        out, err := files.writer(event)
+        if err != nil {
+            return err
+        }
+        if files.spool {
+            out.write(stringValues(event.Data))
+            continue
+        }
+        if out.records == 0 {
+            out.start(cfg.columns(files.seen))
+        }
+        drift := out.drift(event.Data)
+        if len(drift) > 0 && cfg.OnDrift == "fail" {
+            return fmt.Errorf("field %q is not in the columns of %s", drift[0], out.path)
+        }
+        out.write(event.Data)
+        for _, k := range drift {
+            counters.Add("dropped_values", 1)
+            counters.Add("dropped:"+k, 1)
+        }
+        if err := files.advance(event); err != nil {
+            return err
+        }
+    }
+    if err := files.finish(nil); err != nil {
+        return err
+    }
+    // A rolling sink has published every file; the single file stays.
+    var final fileSinkState
+    if files.spool {
+        st, err := publishSpool(cfg, codec, single, cfg.columns(files.seen))
+        if err != nil {
+            return err
+        }
+        final.Files = append(final.Files, st)
+    } else if single != nil {
+        final.Files = append(final.Files, single.state())
+    }
+    data, err := json.Marshal(final)
+    if err != nil {
+        return err
+    }
+    ack(FinalCheckpoint, data)
+    return nil
+}
+
+// fileSinkState is what a file sink resumes from: the files it had open at
+// a checkpoint.
+type fileSinkState struct {
+    Files []fileState `json:"files"`
+}
+
+// fileState is an open file at a checkpoint: how much of it was written,
+// and the columns to write the rest with.
+type fileState struct {
+    Path    string   `json:"path"`
+    Tmp     string   `json:"tmp,omitempty"`
+    Size    int64    `json:"size"` // bytes on disk
+    Records int64    `json:"records"`
+    Columns []string `json:"columns,omitempty"`
+}
+
+// columns returns the columns of a new file, or nil if its JSON records
+// keep every field.
+func (cfg FileSinkConfig) columns(seen map[string]bool) []string {
+    if cfg.Columns != nil || cfg.Format == "jsonl" {
+        return cfg.Columns
+    }
+    cols := make([]string, 0, len(seen))
+    for k := range seen {
+        cols = append(cols, k)
+    }
+    sort.Strings(cols)
+    return cols
+}
+
+// fileSet holds the open files of a sink: the single file at Path or, for
+// a rolling sink, the current file of every bucket that may still receive
+// events. A bucket's file is closed once the watermark passes the bucket's
+// end, or, until the pipeline provides watermarks, once events a whole
+// bucket past its end arrive.
+type fileSet struct {
+    cfg             FileSinkConfig
+    codec           string
+    open            map[time.Time]*fileWriter // by bucket end
+    seq             map[time.Time]int         // next sequence number per bucket
+    maxEventTime    time.Time
+    streamWatermark bool            // use the pipeline's watermark
+    seen            map[string]bool // every field written so far
+    spool           bool            // the single file is spooled (see openSpool)
+}
+
+// bucket returns the end of the event's time bucket, or the zero time
+// without buckets.
+func (fs *fileSet) bucket(e model.Event) time.Time {
+    if fs.cfg.Bucket <= 0 {
+        return time.Time{}
+    }
+    ts := e.Timestamp
+    if ts.IsZero() {
+        ts = time.Now()
+    }
+    return ts.Truncate(fs.cfg.Bucket).Add(fs.cfg.Bucket)
+}
+
+// full reports whether w reached a size or record limit.
+func (fs *fileSet) full(w *fileWriter) bool {
+    return (fs.cfg.MaxRecords > 0 && w.records >= fs.cfg.MaxRecords) ||
+        (fs.cfg.MaxBytes > 0 && w.bytes.n >= fs.cfg.MaxBytes)
+}
+
+// writer returns the file to write e to, rolling its bucket over to a new
+// file if the current one is full.
+func (fs *fileSet) writer(e model.Event) (*fileWriter, error) {
+    if !fs.cfg.rolling() {
+        return fs.open[time.Time{}], nil
+    }
+    bucket := fs.bucket(e)
+    w := fs.open[bucket]
+    if w != nil && fs.full(w) {
+        delete(fs.open, bucket)
+        if err := w.close(); err != nil {
+            return nil, err
+        }
+        w = nil
+    }
+    if w == nil {
+        var err error
+        if w, err = fs.next(bucket); err != nil {
+            return nil, err
+        }
+        fs.open[bucket] = w
+    }
+    return w, nil
+}
+
+// advance tracks the max event time, closing the buckets it leaves a whole
+// bucket behind while there is no pipeline watermark.
+func (fs *fileSet) advance(e model.Event) error {
+    if e.Timestamp.After(fs.maxEventTime) {
+        fs.maxEventTime = e.Timestamp
+    }
+    if fs.streamWatermark || fs.maxEventTime.IsZero() {
+        return nil
+    }
+    return fs.closeUntil(fs.maxEventTime.Add(-fs.cfg.Bucket))
+}
+
+func (fs *fileSet) watermark(wm time.Time) error {
+    fs.streamWatermark = true
+    return fs.closeUntil(wm)
+}
+
+// closeUntil closes the files of the buckets ending at or before t.
+func (fs *fileSet) closeUntil(t time.Time) error {
+    if fs.cfg.Bucket <= 0 {
+        return nil
+    }
+    for _, bucket := range fs.buckets() {
+        if bucket.After(t) {
+            break
+        }
+        w := fs.open[bucket]
+        delete(fs.open, bucket)
+        if err := w.close(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// buckets returns the buckets with an open file, earliest first.
+func (fs *fileSet) buckets() []time.Time {
+    buckets := make([]time.Time, 0, len(fs.open))
+    for b := range fs.open {
+        buckets = append(buckets, b)
+    }
+    sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+    return buckets
+}
+
+// checkpoint flushes every open file and returns the state to resume from.
+func (fs *fileSet) checkpoint() (json.RawMessage, error) {
+    var st fileSinkState
+    for _, bucket := range fs.buckets() {
+        w := fs.open[bucket]
+        if err := w.flush(); err != nil {
+            return nil, err
+        }
+        f := w.state()
+        if fs.spool {
+            f.Columns = fs.cfg.columns(fs.seen)
+        }
+        st.Files = append(st.Files, f)
+    }
+    return json.Marshal(st)
+}
+
+// finish closes every open file at the end of the input or, if the sink
+// failed with err, aborts them so no partial file is published.
+func (fs *fileSet) finish(err error) error {
+    var cerr error
+    for _, bucket := range fs.buckets() {
+        w := fs.open[bucket]
+        if err != nil {
+            w.abort()
+        } else if werr := w.close(); cerr == nil {
+            cerr = werr
+        }
+    }
+    fs.open = nil
+    return cerr
+}
+
+// next opens the next file of bucket, skipping names that already exist,
+// e.g. from a previous run.
+func (fs *fileSet) next(bucket time.Time) (*fileWriter, error) {
+    dir := filepath.Dir(fs.cfg.Path)
+    for {
+        n := fs.seq[bucket]
+        fs.seq[bucket] = n + 1
+        path := filepath.Join(dir, rollingName(filepath.Base(fs.cfg.Path), bucket, n))
+        tmp := hiddenName(path, ".inprogress")
+        if exists(path) || exists(tmp) {
+            continue
+        }
+        w, err := openFileWriter(path, tmp, fs.codec, fs.cfg.Format)
+        if err != nil {
+            return nil, err
+        }
+        return w, nil
+    }
+}
+
+// openSpool opens the spool of a single CSV file without Columns: a hidden
+// file next to it that holds the records, as JSON Lines of their CSV
+// values, until the input ends and publishSpool writes the file with a
+// column for every field seen. Resuming from a checkpoint taken while
+// spooling continues the spool (files); resuming after the file was written
+// starts the spool over with the file's rows, so new fields extend its
+// columns.
+func openSpool(cfg FileSinkConfig, files []fileState, codec string, seen map[string]bool) (*fileWriter, error) {
+    name := hiddenName(cfg.Path, ".spool")
+    var st fileState
+    if len(files) == 1 {
+        st = files[0]
+    }
+    switch st.Path {
+    case name:
+        for _, c := range st.Columns {
+            seen[c] = true
+        }
+        return resumeFileWriter(fileState{Path: name, Size: st.Size, Records: st.Records}, compression.None, "jsonl")
+    case cfg.Path:
+        w, err := openFileWriter(name, "", compression.None, "jsonl")
+        if err != nil {
+            return nil, err
+        }
+        if err := importCSV(w, cfg.Path, codec, seen); err != nil {
+            w.close()
+            os.Remove(name)
+            return nil, err
+        }
+        return w, nil
+    }
+    return openFileWriter(name, "", compression.None, "jsonl")
+}
+
+// importCSV copies the rows of a CSV file into the spool w.
+func importCSV(w *fileWriter, path, codec string, seen map[string]bool) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    zr, err := compression.NewReader(f, codec)
+    if err != nil {
+        return err
+    }
+    defer zr.Close()
+    r := csv.NewReader(zr)
+    header, err := r.Read()
+    if err == io.EOF {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    for _, c := range header {
+        seen[c] = true
+    }
+    for {
+        row, err := r.Read()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+        data := make(map[string]interface{}, len(header))
+        for i, c := range header {
+            data[c] = row[i]
+        }
+        w.write(data)
+    }
+}
+
+// publishSpool writes the CSV file from the closed spool with columns,
+// removes the spool and returns the state of the file.
+func publishSpool(cfg FileSinkConfig, codec string, spool *fileWriter, columns []string) (fileState, error) {
+    out, err := openFileWriter(cfg.Path, hiddenName(cfg.Path, ".inprogress"), codec, "csv")
+    if err != nil {
+        return fileState{}, err
+    }
+    if err := copySpool(out, spool, columns); err != nil {
+        out.abort()
+        return fileState{}, err
+    }
+    if err := out.close(); err != nil {
+        return fileState{}, err
+    }
+    st := out.state()
+    st.Tmp = ""
+    return st, os.Remove(spool.path)
+}
+
+func copySpool(out *fileWriter, spool *fileWriter, columns []string) error {
+    if spool.records == 0 {
+        return nil // no header either, as for any empty file
+    }
+    f, err := os.Open(spool.path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    out.start(columns)
+    dec := json.NewDecoder(bufio.NewReader(f))
+    for {
+        var data map[string]interface{}
+        if err := dec.Decode(&data); err == io.EOF {
+            return nil
+        } else if err != nil {
+            return fmt.Errorf("%s: %w", spool.path, err)
+        }
+        out.write(data)
+    }
+}
+
+// hiddenName returns the hidden name, with suffix, of a file next to path.
+func hiddenName(path, suffix string) string {
+    return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+suffix)
+}
+
+// stringValues returns data with every value as it is written to CSV.
+func stringValues(data map[string]interface{}) map[string]interface{} {
+    values := make(map[string]interface{}, len(data))
+    for k, v := range data {
+        values[k] = toString(v)
+    }
+    return values
+}
+
+// recoverInProgress cleans up after a rolling sink that did not finish. The
+// files in progress at the checkpoint the sink resumes from are truncated
+// to their size then and completed; any other in-progress file of the sink
+// was started after it, so its records are processed again, and it is
+// removed.
+func recoverInProgress(cfg FileSinkConfig, files []fileState, codec string) error {
+    for _, st := range files {
+        if st.Tmp == "" || !exists(st.Tmp) {
+            continue // completed by an earlier restore
+        }
+        w, err := resumeFileWriter(st, codec, cfg.Format)
+        if err != nil {
+            return err
+        }
+        if err := w.close(); err != nil {
+            return err
+        }
+    }
+    dir := filepath.Dir(cfg.Path)
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    inProgress := inProgressName(filepath.Base(cfg.Path))
+    for _, e := range entries {
+        if inProgress.MatchString(e.Name()) {
+            if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+                return err
             }
-            sort.Strings(headers) // optional, or use custom order
-            writer.Write(headers)
-            headersWritten = true
-        }
-        row := make([]string, len(headers))
-        for i, k := range headers {
-            if val, ok := event.Data[k]; ok {
-                row[i] = toString(val)
+        }
+    }
+    return nil
+}
+
+// inProgressName matches the temp names of the files rollingName names
+// after base.
+func inProgressName(base string) *regexp.Regexp {
+    stem, ext := splitExt(base)
+    return regexp.MustCompile(`^\.` + regexp.QuoteMeta(stem) + `-(\d{8}T\d{6}Z-)?\d{4,}` + regexp.QuoteMeta(ext) + `\.inprogress$`)
+}
+
+// splitExt splits base before its extensions, e.g. events and .csv.gz.
+func splitExt(base string) (stem, ext string) {
+    if i := strings.Index(base, "."); i > 0 {
+        return base[:i], base[i:]
+    }
+    return base, ""
+}
+
+// rollingName inserts the bucket end, in UTC, and a sequence number before
+// the extensions of base: events.csv.gz becomes
+// events-20250705T210000Z-0000.csv.gz, or events-0000.csv.gz without
+// buckets.
+func rollingName(base string, bucket time.Time, seq int) string {
+    stem, ext := splitExt(base)
+    if !bucket.IsZero() {
+        stem += "-" + bucket.UTC().Format("20060102T150405Z")
+    }
+    return fmt.Sprintf("%s-%04d%s", stem, seq, ext)
+}
+
+func exists(path string) bool {
+    _, err := os.Lstat(path)
+    return err == nil
+}
+
+// fileWriter writes records to one output file, through a temp file that
+// is renamed to path on close if tmp is set. A flush ends the compressed
+// stream; the next write starts another one after it.
+type fileWriter struct {
+    path, tmp string
+    codec     string
+    f         *os.File
+    size      *countingWriter // bytes on disk
+    buf       *bufio.Writer
+    zw        compression.Writer // nil after a flush until the next write
+    csv       *csv.Writer        // nil for JSON Lines
+    json      *json.Encoder      // nil for CSV
+    bytes     *countingWriter    // uncompressed bytes written
+    columns   []string           // nil: JSON records keep every field
+    inColumns map[string]bool
+    records   int64
+}
+
+func openFileWriter(path, tmp, codec, format string) (*fileWriter, error) {
+    name := path
+    if tmp != "" {
+        name = tmp
+    }
+    f, err := os.Create(name)
+    if err != nil {
+        return nil, err
+    }
+    w := newFileWriter(path, tmp, f, codec, format)
+    if w.zw, err = compression.NewWriter(w.buf, codec); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return w, nil
+}
+
+// resumeFileWriter reopens a file at the state of a checkpoint, dropping
+// whatever was written after it.
+func resumeFileWriter(st fileState, codec, format string) (*fileWriter, error) {
+    name := st.Path
+    if st.Tmp != "" {
+        name = st.Tmp
+    }
+    f, err := os.OpenFile(name, os.O_RDWR, 0)
+    if err != nil {
+        return nil, err
+    }
+    info, err := f.Stat()
+    if err == nil && info.Size() < st.Size {
+        err = fmt.Errorf("%s is shorter than at the checkpoint (%d < %d bytes)", name, info.Size(), st.Size)
+    }
+    if err == nil {
+        err = f.Truncate(st.Size)
+    }
+    if err == nil {
+        _, err = f.Seek(st.Size, io.SeekStart)
+    }
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    w := newFileWriter(st.Path, st.Tmp, f, codec, format)
+    w.size.n = st.Size
+    if w.records = st.Records; w.records > 0 {
+        w.setColumns(st.Columns)
+    }
+    return w, nil
+}
+
+func newFileWriter(path, tmp string, f *os.File, codec, format string) *fileWriter {
+    w := &fileWriter{path: path, tmp: tmp, codec: codec, f: f, size: &countingWriter{w: f}}
+    w.buf = bufio.NewWriter(w.size)
+    w.bytes = &countingWriter{w: compressor{w}}
+    if format == "jsonl" {
+        w.json = json.NewEncoder(w.bytes)
+        w.json.SetEscapeHTML(false)
+    } else {
+        w.csv = csv.NewWriter(w.bytes)
+    }
+    return w
+}
+
+// compressor writes to the file's current compressed stream, starting a
+// new one if a flush ended the last.
+type compressor struct{ w *fileWriter }
+
+func (c compressor) Write(p []byte) (int, error) {
+    if c.w.zw == nil {
+        zw, err := compression.NewWriter(c.w.buf, c.w.codec)
+        if err != nil {
+            return 0, err
+        }
+        c.w.zw = zw
+    }
+    return c.w.zw.Write(p)
+}
+
+// start sets the columns of the file before its first record, writing the
+// CSV header.
+func (w *fileWriter) start(columns []string) {
+    w.setColumns(columns)
+    if w.csv != nil {
+        w.writeCSV(columns)
+    }
+}
+
+func (w *fileWriter) setColumns(columns []string) {
+    w.columns = columns
+    w.inColumns = make(map[string]bool, len(columns))
+    for _, c := range columns {
+        w.inColumns[c] = true
+    }
+}
+
+// state returns how much of the file is written, once it is flushed.
+func (w *fileWriter) state() fileState {
+    return fileState{Path: w.path, Tmp: w.tmp, Size: w.size.n, Records: w.records, Columns: w.columns}
+}
+
+// drift returns the fields of a record that the file has no column for.
+func (w *fileWriter) drift(data map[string]interface{}) []string {
+    if w.json != nil && w.columns == nil {
+        return nil
+    }
+    var dropped []string
+    for k := range data {
+        if !w.inColumns[k] {
+            dropped = append(dropped, k)
+        }
+    }
+    sort.Strings(dropped)
+    return dropped
+}
+
+// write writes a record, leaving out the fields outside the columns. Write
+// errors surface on close.
+func (w *fileWriter) write(data map[string]interface{}) {
+    w.records++
+    if w.json != nil && w.columns == nil {
+        w.json.Encode(data)
+        return
+    }
+    if w.json != nil {
+        rec := make(map[string]interface{}, len(w.columns))
+        for _, c := range w.columns {
+            if val, ok := data[c]; ok {
+                rec[c] = val
             }
         }
-        writer.Write(row)
+        w.json.Encode(rec)
+        return
+    }
+    row := make([]string, len(w.columns))
+    for i, k := range w.columns {
+        if val, ok := data[k]; ok {
+            row[i] = toString(val)
+        }
+    }
+    w.writeCSV(row)
+}
+
+func (w *fileWriter) writeCSV(row []string) {
+    w.csv.Write(row)
+    w.csv.Flush() // keeps the byte count current
+}
+
+// flush ends the compressed stream and writes everything through to disk,
+// so the file can be truncated back to its current size and appended to.
+func (w *fileWriter) flush() error {
+    if w.csv != nil {
+        w.csv.Flush()
+        if err := w.csv.Error(); err != nil {
+            return err
+        }
+    }
+    if w.zw != nil {
+        if err := w.zw.Close(); err != nil {
+            return err
+        }
+        w.zw = nil
+    }
+    for _, step := range []func() error{w.buf.Flush, w.f.Sync} {
+        if err := step(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// close completes the file and, if it was written under a temp name,
+// renames it into place.
+func (w *fileWriter) close() error {
+    var err error
+    if w.csv != nil {
+        w.csv.Flush()
+        err = w.csv.Error()
+    }
+    if w.zw != nil {
+        if zerr := w.zw.Close(); err == nil {
+            err = zerr
+        }
+    }
+    for _, step := range []func() error{w.buf.Flush, w.f.Close} {
+        if serr := step(); err == nil {
+            err = serr
+        }
+    }
+    if err == nil && w.tmp != "" {
+        err = os.Rename(w.tmp, w.path)
+    }
+    return err
+}
+
+// abort closes the file after a failure. A temp file is removed rather
+// than renamed into place; a file written in place keeps what was written.
+func (w *fileWriter) abort() {
+    if w.tmp == "" {
+        w.close()
+        return
     }
-    writer.Flush()
-    return writer.Error()
+    w.f.Close()
+    os.Remove(w.tmp)
+}
+
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
 }
 
 // Helper to stringify interface{} to string
 func toString(val interface{}) string {
-    if s, ok := val.(string); ok {
-        return s
+    if val == nil {
+        return ""
+    }
+    switch v := val.(type) {
+    case string:
+        return v
+    case float64:
+        // Plain decimal, never exponent notation such as 1e+06
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    case map[string]interface{}, []interface{}:
+        // Nested values as JSON rather than Go syntax
+        if b, err := json.Marshal(v); err == nil {
+            return string(b)
+        }
     }
     return fmt.Sprintf("%v", val)
 }
@@ -0,0 +1,251 @@
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goxstream/internal/compression"
+	"goxstream/internal/model"
+)
+
+// runFileSink writes events through a file sink resuming from state, and
+// returns the state it acknowledged for each checkpoint, by ID.
+func runFileSink(t *testing.T, cfg FileSinkConfig, state json.RawMessage, counters *Counters, events ...model.Event) map[int64]json.RawMessage {
+	t.Helper()
+	acked := make(map[int64]json.RawMessage)
+	ack := func(id int64, st json.RawMessage) { acked[id] = st }
+	if err := FileSink(cfg, state, feed(events), ack, counters); err != nil {
+		t.Fatalf("file sink: %v", err)
+	}
+	return acked
+}
+
+// crashAt runs events through a file sink, then puts the directory of its
+// output back as it was when the sink acknowledged checkpoint crash, as if
+// the job had died right then, before the checkpoint was saved. It returns
+// the states acknowledged before.
+func crashAt(t *testing.T, cfg FileSinkConfig, crash int64, events ...model.Event) map[int64]json.RawMessage {
+	t.Helper()
+	dir := filepath.Dir(cfg.Path)
+	acked := make(map[int64]json.RawMessage)
+	var files map[string][]byte
+	ack := func(id int64, st json.RawMessage) {
+		if id == crash {
+			files = readDir(t, dir)
+		} else if files == nil {
+			acked[id] = st
+		}
+	}
+	if err := FileSink(cfg, nil, feed(events), ack, nil); err != nil {
+		t.Fatalf("file sink: %v", err)
+	}
+	if files == nil {
+		t.Fatalf("checkpoint %d was not acknowledged", crash)
+	}
+	for name := range readDir(t, dir) {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return acked
+}
+
+func feed(events []model.Event) <-chan model.Event {
+	in := make(chan model.Event, len(events))
+	for _, e := range events {
+		in <- e
+	}
+	close(in)
+	return in
+}
+
+// readDir returns the content of every file in dir, by name.
+func readDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = data
+	}
+	return files
+}
+
+func rec(v string) model.Event {
+	return model.Event{Data: map[string]interface{}{"v": v}}
+}
+
+func recData(data map[string]interface{}) model.Event {
+	return model.Event{Data: data}
+}
+
+// recAt is a record with event time sec seconds after 21:00 UTC.
+func recAt(v string, sec int) model.Event {
+	e := rec(v)
+	e.Timestamp = time.Date(2025, 7, 5, 21, 0, sec, 0, time.UTC)
+	return e
+}
+
+func barrier(id int64) model.Event {
+	return model.Event{Kind: model.KindBarrier, Checkpoint: id}
+}
+
+// readOutput returns the decompressed content of a sink's file.
+func readOutput(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	codec, err := compression.Detect(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := compression.NewReader(f, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return string(data)
+}
+
+// A sink resuming from a checkpoint keeps what was written before it,
+// drops what was written after it, and appends to the file.
+func TestFileSinkResume(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  FileSinkConfig
+	}{
+		{name: "out.csv"},
+		{name: "out.csv.gz"},
+		{name: "out.csv.lz4", cfg: FileSinkConfig{Columns: []string{"v"}}},
+		{name: "out.jsonl.zst", cfg: FileSinkConfig{Format: "jsonl"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Path = filepath.Join(t.TempDir(), tt.name)
+			line, want := func(v string) string { return v + "\n" }, "v\n"
+			if cfg.Format == "jsonl" {
+				line, want = func(v string) string { return `{"v":"` + v + `"}` + "\n" }, ""
+			}
+			want += line("a") + line("b") + line("d")
+			// The first run dies after c, which checkpoint 2 does not cover.
+			acked := crashAt(t, cfg, 3, rec("a"), barrier(1), rec("b"), barrier(2), rec("c"), barrier(3))
+			final := runFileSink(t, cfg, acked[2], nil, rec("d"))
+			if got := readOutput(t, cfg.Path); got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+			// Resuming from the final checkpoint only appends.
+			runFileSink(t, cfg, final[FinalCheckpoint], nil, rec("e"))
+			if got, want := readOutput(t, cfg.Path), want+line("e"); got != want {
+				t.Errorf("output after the final checkpoint = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFileSinkFreshStartTruncates(t *testing.T) {
+	cfg := FileSinkConfig{Path: filepath.Join(t.TempDir(), "out.csv")}
+	runFileSink(t, cfg, nil, nil, rec("a"))
+	runFileSink(t, cfg, nil, nil, rec("b"))
+	if got := readOutput(t, cfg.Path); got != "v\nb\n" {
+		t.Errorf("output = %q, want only the second run's", got)
+	}
+}
+
+// A rolling sink resuming after a crash completes the file that was in
+// progress at the checkpoint and removes the one started after it.
+func TestFileSinkRecoversInProgressFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := FileSinkConfig{Path: filepath.Join(dir, "out.csv"), Bucket: 10 * time.Second}
+	// Both buckets' files are in progress when the job dies.
+	acked := crashAt(t, cfg, 2, recAt("a", 1), barrier(1), recAt("b", 2), recAt("c", 12), barrier(2))
+	other := filepath.Join(dir, ".out-eu-0000.csv.inprogress")
+	if err := os.WriteFile(other, []byte("v\nx\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runFileSink(t, cfg, acked[1], nil, recAt("b", 2), recAt("c", 12))
+
+	want := map[string]string{
+		".out-eu-0000.csv.inprogress":   "v\nx\n",
+		"out-20250705T210010Z-0000.csv": "v\na\n",
+		"out-20250705T210010Z-0001.csv": "v\nb\n",
+		"out-20250705T210020Z-0000.csv": "v\nc\n",
+	}
+	got := readDir(t, dir)
+	if len(got) != len(want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if string(got[name]) != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+// A single CSV file has a column for every field of any event, including
+// fields that first appear later and after a restart.
+func TestFileSinkHeaderUnion(t *testing.T) {
+	dir := t.TempDir()
+	cfg := FileSinkConfig{Path: filepath.Join(dir, "out.csv")}
+	final := runFileSink(t, cfg, nil, nil,
+		recData(map[string]interface{}{"window_id": 1, "count": 2}),
+		recData(map[string]interface{}{"window_id": 2, "count": 1, "emitted_via_flush": true}),
+	)
+	want := "count,emitted_via_flush,window_id\n2,,1\n1,true,2\n"
+	if got := readOutput(t, cfg.Path); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	runFileSink(t, cfg, final[FinalCheckpoint], nil, recData(map[string]interface{}{"window_id": 3, "city": "Lyon"}))
+	want = "city,count,emitted_via_flush,window_id\n,2,,1\n,1,true,2\nLyon,,,3\n"
+	if got := readOutput(t, cfg.Path); got != want {
+		t.Errorf("output after a restart = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".out.csv.spool")); !os.IsNotExist(err) {
+		t.Errorf("the spool was not removed: %v", err)
+	}
+}
+
+// Fields outside the columns are counted, per field and in total.
+func TestFileSinkDroppedValues(t *testing.T) {
+	cfg := FileSinkConfig{Path: filepath.Join(t.TempDir(), "out.csv"), Columns: []string{"v"}}
+	counters := &Counters{}
+	runFileSink(t, cfg, nil, counters,
+		recData(map[string]interface{}{"v": "a", "x": 1}),
+		recData(map[string]interface{}{"v": "b", "x": 2, "y": 3}),
+	)
+	got := counters.Counters()
+	want := map[string]int64{"dropped_values": 3, "dropped:x": 2, "dropped:y": 1}
+	if len(got) != len(want) {
+		t.Fatalf("counters = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("counters = %v, want %v", got, want)
+			break
+		}
+	}
+	if out := readOutput(t, cfg.Path); out != "v\na\nb\n" {
+		t.Errorf("output = %q", out)
+	}
+}
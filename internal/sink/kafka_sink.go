@@ -13,15 +13,24 @@ type KafkaSinkConfig struct {
     Topic   string
 }
 
-func KafkaSink(cfg KafkaSinkConfig, in <-chan model.Event) error {
+// KafkaSink writes each record synchronously, so a barrier is acknowledged
+// as soon as it is received.
+func KafkaSink(ctx context.Context, cfg KafkaSinkConfig, in <-chan model.Event, ack func(checkpoint int64)) error {
     w := kafka.NewWriter(kafka.WriterConfig{
         Brokers: cfg.Brokers,
         Topic:   cfg.Topic,
     })
     defer w.Close()
 
-    ctx := context.Background()
+    ctx, cancel := drainContext(ctx)
+    defer cancel()
     for event := range in {
+        if !event.IsRecord() {
+            if event.Kind == model.KindBarrier {
+                ack(event.Checkpoint)
+            }
+            continue
+        }
         data, err := json.Marshal(event.Data)
         if err != nil {
             continue
@@ -1,13 +1,66 @@
 package sink
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"goxstream/internal/model"
+	"sync"
+	"time"
 )
 
 // -------- Sink Registry --------
 
-type SinkFactory func(params map[string]interface{}, in <-chan model.Event) error
+// A SinkFactory consumes events from in until it is closed. Events that are
+// already in flight when ctx is cancelled are still written. Checkpoint
+// barriers and watermarks arrive in line with the records; the sink calls
+// ack with a barrier's ID once every record before it is written and
+// flushed, and may ignore watermarks. state is what the sink acknowledged
+// for the checkpoint the job resumes from, nil on a fresh start. The sink
+// keeps counts of what it does, such as values it dropped, in counters.
+type SinkFactory func(ctx context.Context, params map[string]interface{}, state json.RawMessage, in <-chan model.Event, ack Ack, counters *Counters) error
+
+// An Ack acknowledges a checkpoint barrier together with the state the sink
+// needs to resume from it, such as the size of a file it appends to; state
+// may be nil. A sink with state also acknowledges FinalCheckpoint once in is
+// closed and everything is written, for the checkpoint taken when the
+// pipeline stops.
+type Ack func(checkpoint int64, state json.RawMessage)
+
+// FinalCheckpoint stands for the checkpoint taken when the pipeline stops,
+// whose ID is only known then.
+const FinalCheckpoint int64 = 0
+
+// Counters are the named counts a sink keeps. They may be read while the
+// sink runs; a nil *Counters discards them.
+type Counters struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Add adds n to the count name.
+func (c *Counters) Add(name string, n int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[name] += n
+}
+
+// Counters returns a copy of the counts.
+func (c *Counters) Counters() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
 
 var registry = map[string]SinkFactory{
 	"file":  fileSinkFactory,
@@ -15,8 +68,10 @@ var registry = map[string]SinkFactory{
 	"kafka": kafkaSinkFactory,
 }
 
-// BuildSink dynamically constructs the sink based on JSON spec
-func BuildSink(sinkSpec map[string]interface{}, in <-chan model.Event) error {
+// BuildSink dynamically constructs the sink based on JSON spec. ack may be
+// nil when the pipeline does not checkpoint, and counters when nothing
+// reads them.
+func BuildSink(ctx context.Context, sinkSpec map[string]interface{}, state json.RawMessage, in <-chan model.Event, ack Ack, counters *Counters) error {
 	sinkType, ok := sinkSpec["type"].(string)
 	if !ok {
 		return fmt.Errorf("sink missing 'type'")
@@ -25,32 +80,94 @@ func BuildSink(sinkSpec map[string]interface{}, in <-chan model.Event) error {
 	if !ok {
 		return fmt.Errorf("unknown sink type: %s", sinkType)
 	}
-	return factory(sinkSpec, in)
+	if ack == nil {
+		ack = func(int64, json.RawMessage) {}
+	}
+	return factory(ctx, sinkSpec, state, in, ack, counters)
 }
 
 // -------- Adapters for each sink type --------
 
-// File sink expects: { "type": "file", "path": "output.csv" }
-func fileSinkFactory(params map[string]interface{}, in <-chan model.Event) error {
+// File sink expects: { "type": "file", "path": "output.csv" }. A path
+// ending in .gz, .zst, .lz4 or .snappy is compressed, as is any path with
+// "compression" set. "max_bytes", "max_records" and "bucket" (a duration
+// of event time) roll the output over into numbered files. "format": "jsonl"
+// writes JSON Lines, "columns" fixes the fields written and "on_drift":
+// "fail" stops the sink at the first field outside them.
+func fileSinkFactory(ctx context.Context, params map[string]interface{}, state json.RawMessage, in <-chan model.Event, ack Ack, counters *Counters) error {
 	path, ok := params["path"].(string)
 	if !ok {
 		return fmt.Errorf("file sink expects 'path'")
 	}
-	return FileSink(path, in)
+	cfg := FileSinkConfig{Path: path}
+	cfg.Compression, _ = params["compression"].(string)
+	var err error
+	if cfg.MaxBytes, err = positiveInt(params, "max_bytes"); err != nil {
+		return err
+	}
+	if cfg.MaxRecords, err = positiveInt(params, "max_records"); err != nil {
+		return err
+	}
+	cfg.Format, _ = params["format"].(string)
+	switch cfg.Format {
+	case "", "csv", "jsonl":
+	default:
+		return fmt.Errorf("file sink format must be csv or jsonl, got %q", cfg.Format)
+	}
+	if v, ok := params["columns"]; ok {
+		cols, ok := v.([]interface{})
+		if !ok || len(cols) == 0 {
+			return fmt.Errorf("file sink columns must be a list of names")
+		}
+		for _, c := range cols {
+			name, ok := c.(string)
+			if !ok || name == "" {
+				return fmt.Errorf("file sink columns must be a list of names")
+			}
+			cfg.Columns = append(cfg.Columns, name)
+		}
+	}
+	cfg.OnDrift, _ = params["on_drift"].(string)
+	switch cfg.OnDrift {
+	case "", "report", "fail":
+	default:
+		return fmt.Errorf("file sink on_drift must be report or fail, got %q", cfg.OnDrift)
+	}
+	if b, ok := params["bucket"].(string); ok {
+		d, err := time.ParseDuration(b)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid file sink bucket: %q", b)
+		}
+		cfg.Bucket = d
+	}
+	return FileSink(cfg, state, in, ack, counters)
+}
+
+// positiveInt reads an optional file sink limit; 0 means none.
+func positiveInt(params map[string]interface{}, name string) (int64, error) {
+	v, ok := params[name]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(float64)
+	if !ok || n < 1 || n != float64(int64(n)) {
+		return 0, fmt.Errorf("file sink %s must be a positive integer", name)
+	}
+	return int64(n), nil
 }
 
 // DB sink expects: { "type": "db", "dsn": "...", "table": "..." }
-func dbSinkFactory(params map[string]interface{}, in <-chan model.Event) error {
+func dbSinkFactory(ctx context.Context, params map[string]interface{}, _ json.RawMessage, in <-chan model.Event, ack Ack, _ *Counters) error {
 	dsn, ok1 := params["dsn"].(string)
 	table, ok2 := params["table"].(string)
 	if !ok1 || !ok2 {
 		return fmt.Errorf("db sink expects 'dsn' and 'table'")
 	}
-	return DBSink(DBSinkConfig{DSN: dsn, Table: table}, in)
+	return DBSink(ctx, DBSinkConfig{DSN: dsn, Table: table}, in, statelessAck(ack))
 }
 
 // Kafka sink expects: { "type": "kafka", "brokers": [...], "topic": "..." }
-func kafkaSinkFactory(params map[string]interface{}, in <-chan model.Event) error {
+func kafkaSinkFactory(ctx context.Context, params map[string]interface{}, _ json.RawMessage, in <-chan model.Event, ack Ack, _ *Counters) error {
 	brokersIface, ok1 := params["brokers"].([]interface{})
 	topic, ok2 := params["topic"].(string)
 	if !ok1 || !ok2 {
@@ -64,8 +181,13 @@ func kafkaSinkFactory(params map[string]interface{}, in <-chan model.Event) erro
 		}
 		brokers[i] = str
 	}
-	return KafkaSink(KafkaSinkConfig{
+	return KafkaSink(ctx, KafkaSinkConfig{
 		Brokers: brokers,
 		Topic:   topic,
-	}, in)
+	}, in, statelessAck(ack))
+}
+
+// statelessAck adapts ack for a sink that has no state to resume from.
+func statelessAck(ack Ack) func(checkpoint int64) {
+	return func(checkpoint int64) { ack(checkpoint, nil) }
 }
@@ -0,0 +1,24 @@
+package sink
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownGrace bounds how long a sink keeps writing in-flight events after
+// its job has been cancelled.
+const shutdownGrace = 10 * time.Second
+
+// drainContext returns a context for sink writes that outlives ctx by
+// shutdownGrace, so events already flowing through the pipeline can still be
+// written after cancellation without letting a stuck sink hang forever.
+func drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(shutdownGrace, cancel)
+	})
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
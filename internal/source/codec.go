@@ -0,0 +1,342 @@
+package source
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	// Types gob needs to decode records whose values are interfaces.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+}
+
+// Codec describes how the records of a file are encoded, e.g.
+// { "format": "csv", "delimiter": ";", "header": false, "columns": ["id", "score"],
+//   "types": { "score": "float" } }.
+type Codec struct {
+	Format    string            // csv (default), tsv, jsonl, fixed_width or gob
+	Delimiter rune              // csv and tsv field separator
+	Quote     rune              // csv and tsv quote character; 0 disables quoting
+	NoHeader  bool              // csv and tsv: the first row is a record, not the column names
+	Columns   []string          // column names; replace the header row if there is one
+	Widths    []int             // fixed_width: characters per column
+	Types     map[string]string // column type hints: string, int, float or bool
+}
+
+// A Decoder returns the records of a file one at a time, and io.EOF after
+// the last one.
+type Decoder interface {
+	Next() (map[string]interface{}, error)
+}
+
+// parseCodec reads the codec params of a file source.
+func parseCodec(params map[string]interface{}) (Codec, error) {
+	c := Codec{Format: "csv"}
+	if f, ok := params["format"].(string); ok && f != "" {
+		c.Format = f
+	}
+	switch c.Format {
+	case "csv":
+		c.Delimiter, c.Quote = ',', '"'
+	case "tsv":
+		c.Delimiter = '\t'
+	case "jsonl", "fixed_width", "gob":
+	default:
+		return c, fmt.Errorf("file format must be csv, tsv, jsonl, fixed_width or gob, got %q", c.Format)
+	}
+	if v, ok := params["delimiter"]; ok {
+		d, ok := v.(string)
+		if !ok || utf8.RuneCountInString(d) != 1 || d == "\n" || d == "\r" {
+			return c, fmt.Errorf("file delimiter must be a single character")
+		}
+		c.Delimiter, _ = utf8.DecodeRuneInString(d)
+	}
+	if v, ok := params["quote"]; ok {
+		q, ok := v.(string)
+		if !ok || utf8.RuneCountInString(q) > 1 {
+			return c, fmt.Errorf("file quote must be a single character, or empty to disable quoting")
+		}
+		c.Quote, _ = utf8.DecodeRuneInString(q)
+		if q == "" {
+			c.Quote = 0
+		}
+	}
+	if c.Quote != 0 && c.Quote == c.Delimiter {
+		return c, fmt.Errorf("file quote and delimiter must differ")
+	}
+	if h, ok := params["header"].(bool); ok {
+		c.NoHeader = !h
+	}
+	if v, ok := params["columns"]; ok {
+		cols, ok := v.([]interface{})
+		if !ok {
+			return c, fmt.Errorf("file columns must be a list of names")
+		}
+		for _, col := range cols {
+			name, ok := col.(string)
+			if !ok || name == "" {
+				return c, fmt.Errorf("file columns must be a list of names")
+			}
+			c.Columns = append(c.Columns, name)
+		}
+	}
+	if v, ok := params["widths"]; ok {
+		ws, ok := v.([]interface{})
+		if !ok {
+			return c, fmt.Errorf("file widths must be a list of positive integers")
+		}
+		for _, w := range ws {
+			n, ok := w.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return c, fmt.Errorf("file widths must be a list of positive integers")
+			}
+			c.Widths = append(c.Widths, int(n))
+		}
+	}
+	if v, ok := params["types"]; ok {
+		types, ok := v.(map[string]interface{})
+		if !ok {
+			return c, fmt.Errorf("file types must map column names to types")
+		}
+		c.Types = make(map[string]string, len(types))
+		for col, t := range types {
+			switch t {
+			case "string", "int", "float", "bool":
+				c.Types[col] = t.(string)
+			default:
+				return c, fmt.Errorf("file type of %q must be string, int, float or bool, got %v", col, t)
+			}
+		}
+	}
+
+	switch {
+	case (c.Format == "csv" || c.Format == "tsv") && c.NoHeader && len(c.Columns) == 0:
+		return c, fmt.Errorf("file without header expects 'columns'")
+	case c.Format == "fixed_width" && (len(c.Columns) == 0 || len(c.Widths) != len(c.Columns)):
+		return c, fmt.Errorf("fixed_width file expects 'columns' and as many 'widths'")
+	}
+	return c, nil
+}
+
+// NewDecoder returns a decoder reading records from r.
+func (c Codec) NewDecoder(r io.Reader) (Decoder, error) {
+	var d Decoder
+	switch c.Format {
+	case "jsonl":
+		d = &jsonDecoder{dec: json.NewDecoder(r)}
+	case "gob":
+		d = &gobDecoder{dec: gob.NewDecoder(r)}
+	case "fixed_width":
+		d = &fixedWidthDecoder{lines: bufio.NewReader(r), columns: c.Columns, widths: c.Widths}
+	default:
+		dd := &delimitedDecoder{lines: bufio.NewReader(r), delim: c.Delimiter, quote: c.Quote, columns: c.Columns}
+		if !c.NoHeader {
+			// An empty file has no header and no records.
+			header, err := dd.fields()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("reading header: %w", err)
+			}
+			if dd.columns == nil {
+				dd.columns = header
+			}
+		}
+		d = dd
+	}
+	if len(c.Types) == 0 {
+		return d, nil
+	}
+	return &typedDecoder{Decoder: d, types: c.Types}, nil
+}
+
+type jsonDecoder struct{ dec *json.Decoder }
+
+func (d *jsonDecoder) Next() (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := d.dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type gobDecoder struct{ dec *gob.Decoder }
+
+func (d *gobDecoder) Next() (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := d.dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readLine returns the next line without its line ending, and io.EOF once
+// there are no more.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// delimitedDecoder reads csv and tsv. Unlike encoding/csv, it takes any
+// quote character, or none. A quoted field may contain delimiters, line
+// breaks and doubled quote characters.
+type delimitedDecoder struct {
+	lines   *bufio.Reader
+	delim   rune
+	quote   rune
+	columns []string
+}
+
+func (d *delimitedDecoder) Next() (map[string]interface{}, error) {
+	fields, err := d.fields()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{}, len(d.columns))
+	for i, col := range d.columns {
+		if i < len(fields) {
+			data[col] = fields[i]
+		} else {
+			data[col] = ""
+		}
+	}
+	return data, nil
+}
+
+// fields splits the next non-empty row into its fields.
+func (d *delimitedDecoder) fields() ([]string, error) {
+	line, err := readLine(d.lines)
+	for err == nil && line == "" {
+		line, err = readLine(d.lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	var field strings.Builder
+	inQuotes, quoted := false, false
+	for {
+		runes := []rune(line)
+		for i := 0; i < len(runes); i++ {
+			c := runes[i]
+			switch {
+			case inQuotes && c == d.quote:
+				if i+1 < len(runes) && runes[i+1] == d.quote {
+					field.WriteRune(c)
+					i++
+				} else {
+					inQuotes = false
+				}
+			case inQuotes:
+				field.WriteRune(c)
+			case d.quote != 0 && c == d.quote && field.Len() == 0 && !quoted:
+				inQuotes, quoted = true, true
+			case c == d.delim:
+				fields = append(fields, field.String())
+				field.Reset()
+				quoted = false
+			default:
+				field.WriteRune(c)
+			}
+		}
+		if !inQuotes {
+			break
+		}
+		// The quoted field continues on the next line.
+		field.WriteRune('\n')
+		if line, err = readLine(d.lines); err != nil {
+			if err == io.EOF {
+				err = errors.New("unterminated quoted field")
+			}
+			return nil, err
+		}
+	}
+	return append(fields, field.String()), nil
+}
+
+// fixedWidthDecoder cuts each line into columns of fixed widths, trimming
+// the padding around values. Short lines leave their last columns empty.
+type fixedWidthDecoder struct {
+	lines   *bufio.Reader
+	columns []string
+	widths  []int
+}
+
+func (d *fixedWidthDecoder) Next() (map[string]interface{}, error) {
+	line, err := readLine(d.lines)
+	for err == nil && strings.TrimSpace(line) == "" {
+		line, err = readLine(d.lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(line)
+	data := make(map[string]interface{}, len(d.columns))
+	pos := 0
+	for i, col := range d.columns {
+		end := pos + d.widths[i]
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if pos < end {
+			data[col] = strings.TrimSpace(string(runes[pos:end]))
+		} else {
+			data[col] = ""
+		}
+		pos = end
+	}
+	return data, nil
+}
+
+// typedDecoder converts the text values of hinted columns. Empty values
+// become null; values of other types, e.g. JSON numbers, are kept.
+type typedDecoder struct {
+	Decoder
+	types map[string]string
+}
+
+func (d *typedDecoder) Next() (map[string]interface{}, error) {
+	data, err := d.Decoder.Next()
+	if err != nil {
+		return nil, err
+	}
+	for col, t := range d.types {
+		s, ok := data[col].(string)
+		if !ok || t == "string" {
+			continue
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			data[col] = nil
+			continue
+		}
+		var v interface{}
+		switch t {
+		case "int":
+			v, err = strconv.ParseInt(s, 10, 64)
+		case "float":
+			v, err = strconv.ParseFloat(s, 64)
+		case "bool":
+			v, err = strconv.ParseBool(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %q is not a valid %s", col, s, t)
+		}
+		data[col] = v
+	}
+	return data, nil
+}
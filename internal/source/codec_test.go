@@ -0,0 +1,201 @@
+package source
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeAll decodes every record of input with the codec described by params.
+func decodeAll(params map[string]interface{}, input string) ([]map[string]interface{}, error) {
+	c, err := parseCodec(params)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.NewDecoder(strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	for {
+		r, err := d.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, r)
+	}
+}
+
+type rec = map[string]interface{}
+
+func TestDecodeDelimited(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		input  string
+		want   []map[string]interface{}
+	}{
+		{
+			name:  "quoted delimiter",
+			input: "id,name\n1,\"Smith, John\"\n",
+			want:  []rec{{"id": "1", "name": "Smith, John"}},
+		},
+		{
+			name:  "doubled quote",
+			input: "id,name\n1,\"say \"\"hi\"\"\"\n",
+			want:  []rec{{"id": "1", "name": `say "hi"`}},
+		},
+		{
+			name:  "embedded newline",
+			input: "id,note\n1,\"first\nsecond\"\n2,x\n",
+			want:  []rec{{"id": "1", "note": "first\nsecond"}, {"id": "2", "note": "x"}},
+		},
+		{
+			name:  "crlf and blank lines",
+			input: "id,name\r\n\r\n1,a\r\n",
+			want:  []rec{{"id": "1", "name": "a"}},
+		},
+		{
+			name:  "short row",
+			input: "a,b,c\n1\n",
+			want:  []rec{{"a": "1", "b": "", "c": ""}},
+		},
+		{
+			name:   "custom delimiter and quote",
+			params: map[string]interface{}{"delimiter": ";", "quote": "'"},
+			input:  "id;name\n1;'a;b'\n",
+			want:   []rec{{"id": "1", "name": "a;b"}},
+		},
+		{
+			name:   "quoting disabled",
+			params: map[string]interface{}{"quote": ""},
+			input:  "id,name\n1,\"a\n",
+			want:   []rec{{"id": "1", "name": `"a`}},
+		},
+		{
+			name:   "tsv",
+			params: map[string]interface{}{"format": "tsv"},
+			input:  "id\tname\n1\tSmith, John\n",
+			want:   []rec{{"id": "1", "name": "Smith, John"}},
+		},
+		{
+			name:   "no header",
+			params: map[string]interface{}{"header": false, "columns": []interface{}{"id", "name"}},
+			input:  "1,a\n2,b\n",
+			want:   []rec{{"id": "1", "name": "a"}, {"id": "2", "name": "b"}},
+		},
+		{
+			name:   "columns replace header",
+			params: map[string]interface{}{"columns": []interface{}{"x", "y"}},
+			input:  "id,name\n1,a\n",
+			want:   []rec{{"x": "1", "y": "a"}},
+		},
+		{
+			name:  "empty file",
+			input: "",
+		},
+		{
+			name:   "type hints",
+			params: map[string]interface{}{"types": map[string]interface{}{"n": "int", "f": "float", "b": "bool", "s": "string"}},
+			input:  "n,f,b,s\n 7 ,1.5,true,08\n,,,\n",
+			want: []rec{
+				{"n": int64(7), "f": 1.5, "b": true, "s": "08"},
+				{"n": nil, "f": nil, "b": nil, "s": ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			got, err := decodeAll(params, tt.input)
+			if err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		input  string
+		want   string
+	}{
+		{
+			name:  "unterminated quote",
+			input: "id,name\n1,\"open\n2,b\n",
+			want:  "unterminated quoted field",
+		},
+		{
+			name:  "unterminated quote in header",
+			input: "id,\"name\n",
+			want:  "reading header: unterminated quoted field",
+		},
+		{
+			name:   "int hint",
+			params: map[string]interface{}{"types": map[string]interface{}{"n": "int"}},
+			input:  "n\n1.5\n",
+			want:   `column "n": "1.5" is not a valid int`,
+		},
+		{
+			name:   "float hint",
+			params: map[string]interface{}{"types": map[string]interface{}{"f": "float"}},
+			input:  "f\nabc\n",
+			want:   `column "f": "abc" is not a valid float`,
+		},
+		{
+			name:   "bool hint",
+			params: map[string]interface{}{"types": map[string]interface{}{"b": "bool"}},
+			input:  "b\nmaybe\n",
+			want:   `column "b": "maybe" is not a valid bool`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			_, err := decodeAll(params, tt.input)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCodecErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{"unknown format", map[string]interface{}{"format": "xml"}, `file format must be csv, tsv, jsonl, fixed_width or gob, got "xml"`},
+		{"long delimiter", map[string]interface{}{"delimiter": ";;"}, "file delimiter must be a single character"},
+		{"newline delimiter", map[string]interface{}{"delimiter": "\n"}, "file delimiter must be a single character"},
+		{"quote equals delimiter", map[string]interface{}{"delimiter": "'", "quote": "'"}, "file quote and delimiter must differ"},
+		{"no header without columns", map[string]interface{}{"header": false}, "file without header expects 'columns'"},
+		{"bad columns", map[string]interface{}{"columns": []interface{}{"a", 1.0}}, "file columns must be a list of names"},
+		{"unknown type hint", map[string]interface{}{"types": map[string]interface{}{"d": "date"}}, `file type of "d" must be string, int, float or bool, got date`},
+		{"fixed_width without widths", map[string]interface{}{"format": "fixed_width", "columns": []interface{}{"a"}}, "fixed_width file expects 'columns' and as many 'widths'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCodec(tt.params)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
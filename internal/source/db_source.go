@@ -14,17 +14,27 @@ type DBSourceConfig struct {
     Query string
 }
 
-func DBSource(cfg DBSourceConfig, out chan<- model.Event) error {
+// dbRowKey is the offset key of DB source rows. Resuming by row number
+// assumes the query returns rows in a stable order.
+const dbRowKey = "db/row"
+
+func DBSource(ctx context.Context, cfg DBSourceConfig, resume model.Offsets, out chan<- model.Event) error {
     db, err := sql.Open("postgres", cfg.DSN)
     if err != nil { return fmt.Errorf("open db: %w", err) }
     defer db.Close()
 
-    rows, err := db.QueryContext(context.Background(), cfg.Query)
+    rows, err := db.QueryContext(ctx, cfg.Query)
     if err != nil { return fmt.Errorf("query: %w", err) }
     defer rows.Close()
 
     cols, _ := rows.Columns()
+    skip := resume[dbRowKey]
+    var row int64
     for rows.Next() {
+        row++
+        if row <= skip {
+            continue
+        }
         values := make([]interface{}, len(cols))
         ptrs := make([]interface{}, len(cols))
         for i := range values {
@@ -35,13 +45,17 @@ func DBSource(cfg DBSourceConfig, out chan<- model.Event) error {
         }
         data := map[string]interface{}{}
         for i, col := range cols {
-            val := values[i]
-            // Optionally: Parse time columns for Timestamp
-            data[col] = val
+            data[col] = values[i]
         }
-        // Use time.Now() or extract a timestamp column if available
-        out <- model.Event{Data: data, Timestamp: time.Now()}
+        // Processing time, unless the source has a timestamp block
+        select {
+        case out <- model.Event{Data: data, Timestamp: time.Now(), Offset: &model.Offset{Key: dbRowKey, Value: row}}:
+        case <-ctx.Done():
+            return nil
+        }
+    }
+    if err := rows.Err(); err != nil && ctx.Err() == nil {
+        return fmt.Errorf("rows: %w", err)
     }
-    close(out)
     return nil
 }
@@ -1,63 +1,272 @@
 package source
 
 import (
-    "bufio"
-    "encoding/csv"
+    "context"
+    "errors"
+    "fmt"
+    "io"
     "os"
+    "path/filepath"
+    "sort"
     "strings"
     "time"
+    "goxstream/internal/compression"
     "goxstream/internal/model"
 )
 
-func FileSource(path string, out chan<- model.Event) error {
+// FileSourceConfig sets the files to read and how their records are encoded.
+type FileSourceConfig struct {
+    Path   string        // a file, a directory or a glob pattern
+    Order  string        // name (default) or mtime: the order files are read in
+    Follow bool          // keep reading appended records and new files, like tail -F
+    Poll   time.Duration // how often a followed source checks for new data
+    // Compression is the codec of every file (see compression.Detect); by
+    // default each file's extension decides.
+    Compression string
+    Codec
+}
+
+// FileSource reads the records of a file, or of every file in a directory or
+// matching a glob pattern, one file after the other. Files are read as CSV
+// with a header row by default. Records are numbered from 1 within their
+// file; the file path and that number are their offset.
+//
+// With Follow, the source does not stop at the end of the last file: it
+// waits for records to be appended, and moves on to new files as they
+// appear. A file that is truncated or replaced is read again from the start.
+func FileSource(ctx context.Context, cfg FileSourceConfig, resume model.Offsets, out chan<- model.Event) error {
+    read := make(map[string]bool)
+    for {
+        files, err := cfg.files()
+        if err != nil {
+            return err
+        }
+        var pending []string
+        for _, path := range files {
+            if !read[path] {
+                pending = append(pending, path)
+            }
+        }
+        if len(pending) == 0 {
+            if !cfg.Follow {
+                return nil
+            }
+            select {
+            case <-time.After(cfg.Poll):
+                continue
+            case <-ctx.Done():
+                return nil
+            }
+        }
+        for i, path := range pending {
+            read[path] = true
+            var tail func() bool
+            if cfg.Follow && i == len(pending)-1 {
+                // Follow the newest file until a newer one appears.
+                tail = func() bool { return !cfg.hasNew(read) }
+            }
+            if err := cfg.readFile(ctx, path, resume[path], tail, out); err != nil {
+                return err
+            }
+            if ctx.Err() != nil {
+                return nil
+            }
+        }
+        if !cfg.Follow {
+            return nil
+        }
+    }
+}
+
+// files lists the files to read, in order.
+func (cfg FileSourceConfig) files() ([]string, error) {
+    var paths []string
+    info, err := os.Stat(cfg.Path)
+    switch {
+    case err == nil && info.IsDir():
+        entries, err := os.ReadDir(cfg.Path)
+        if err != nil {
+            return nil, err
+        }
+        for _, e := range entries {
+            if e.Type().IsRegular() {
+                paths = append(paths, filepath.Join(cfg.Path, e.Name()))
+            }
+        }
+    case err == nil || !strings.ContainsAny(cfg.Path, `*?[\`):
+        // A single file; a missing one fails when it is opened.
+        return []string{cfg.Path}, nil
+    default:
+        matches, err := filepath.Glob(cfg.Path)
+        if err != nil {
+            return nil, err
+        }
+        for _, m := range matches {
+            if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+                paths = append(paths, m)
+            }
+        }
+    }
+
+    // Hidden files, such as the temp files of a sink still writing, are
+    // skipped.
+    visible := paths[:0]
+    for _, p := range paths {
+        if !strings.HasPrefix(filepath.Base(p), ".") {
+            visible = append(visible, p)
+        }
+    }
+    paths = visible
+    sort.Strings(paths)
+    if cfg.Order == "mtime" {
+        mtimes := make(map[string]time.Time, len(paths))
+        for _, p := range paths {
+            if info, err := os.Stat(p); err == nil {
+                mtimes[p] = info.ModTime()
+            }
+        }
+        sort.SliceStable(paths, func(i, j int) bool { return mtimes[paths[i]].Before(mtimes[paths[j]]) })
+    }
+    return paths, nil
+}
+
+// hasNew reports whether a file not read yet has appeared.
+func (cfg FileSourceConfig) hasNew(read map[string]bool) bool {
+    files, err := cfg.files()
+    if err != nil {
+        return false
+    }
+    for _, path := range files {
+        if !read[path] {
+            return true
+        }
+    }
+    return false
+}
+
+// errReplaced reports that a followed file was truncated or replaced.
+var errReplaced = errors.New("file replaced")
+
+// readFile emits the records of one file after the first skip. With tail
+// set, the end of the file is only final once tail returns false.
+func (cfg FileSourceConfig) readFile(ctx context.Context, path string, skip int64, tail func() bool, out chan<- model.Event) error {
     f, err := os.Open(path)
     if err != nil {
         return err
     }
     defer f.Close()
 
-    reader := csv.NewReader(bufio.NewReader(f))
-    headers, err := reader.Read()
+    var r io.Reader = f
+    if tail != nil {
+        r = &tailReader{ctx: ctx, f: f, path: path, poll: cfg.Poll, wait: tail}
+    }
+    codec, err := compression.Detect(path, cfg.Compression)
     if err != nil {
         return err
     }
-
-    // Find index of "timestamp" column, case-insensitive
-    timestampIdx := -1
-    for i, h := range headers {
-        if strings.EqualFold(h, "timestamp") {
-            timestampIdx = i
-            break
-        }
+    var dec Decoder
+    zr, err := compression.NewReader(r, codec)
+    if err == nil {
+        defer zr.Close()
+        dec, err = cfg.NewDecoder(zr)
+    }
+    if errors.Is(err, errReplaced) {
+        return cfg.readFile(ctx, path, 0, tail, out)
+    }
+    if ctx.Err() != nil || err == io.EOF { // io.EOF: an empty compressed file
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("%s: %w", path, err)
     }
 
+    var line int64
     for {
-        record, err := reader.Read()
+        data, err := dec.Next()
+        if errors.Is(err, errReplaced) {
+            return cfg.readFile(ctx, path, 0, tail, out)
+        }
+        if ctx.Err() != nil || err == io.EOF {
+            return nil
+        }
         if err != nil {
-            break
+            return fmt.Errorf("%s: record %d: %w", path, line+1, err)
         }
-        data := map[string]interface{}{}
-        for i, h := range headers {
-            data[h] = record[i]
+        line++
+        if line <= skip {
+            continue
         }
 
-        var evtTime time.Time
-        if timestampIdx != -1 {
-            // Try to parse as RFC3339, else fallback to time.Now()
-            if t, err := time.Parse(time.RFC3339, record[timestampIdx]); err == nil {
-                evtTime = t
-            } else {
-                evtTime = time.Now()
-            }
-        } else {
-            evtTime = time.Now()
+        select {
+        case out <- model.Event{Data: data, Timestamp: recordTime(data), Offset: &model.Offset{Key: path, Value: line}}:
+        case <-ctx.Done():
+            return nil
         }
+    }
+}
+
+// tailReader reads a file that may still grow. At its end, it polls for
+// appended data for as long as wait returns true, and returns io.EOF
+// otherwise or once ctx is done.
+type tailReader struct {
+    ctx  context.Context
+    f    *os.File
+    path string
+    poll time.Duration
+    wait func() bool
+    n    int64 // bytes read
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+    for {
+        n, err := t.f.Read(p)
+        t.n += int64(n)
+        if n > 0 || err != io.EOF {
+            return n, err
+        }
+        if t.replaced() {
+            return 0, errReplaced
+        }
+        if !t.wait() {
+            // Data may have been appended before the newer file appeared.
+            n, err = t.f.Read(p)
+            t.n += int64(n)
+            return n, err
+        }
+        select {
+        case <-time.After(t.poll):
+        case <-t.ctx.Done():
+            return 0, io.EOF
+        }
+    }
+}
 
-        out <- model.Event{
-            Data:      data,
-            Timestamp: evtTime,
+// replaced reports whether the file at path is no longer the one being
+// read, or was truncated below what has been read.
+func (t *tailReader) replaced() bool {
+    cur, err := os.Stat(t.path)
+    if err != nil {
+        return false
+    }
+    open, err := t.f.Stat()
+    return err == nil && (!os.SameFile(open, cur) || cur.Size() < t.n)
+}
+
+// recordTime reads a "timestamp" column (case-insensitive) as RFC3339, and
+// falls back to time.Now().
+func recordTime(data map[string]interface{}) time.Time {
+    for k, v := range data {
+        if strings.EqualFold(k, "timestamp") {
+            switch ts := v.(type) {
+            case time.Time:
+                return ts
+            case string:
+                if t, err := time.Parse(time.RFC3339, ts); err == nil {
+                    return t
+                }
+            }
+            break
         }
     }
-    close(out)
-    return nil
+    return time.Now()
 }
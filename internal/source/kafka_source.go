@@ -3,8 +3,11 @@ package source
 import (
     "context"
     "encoding/json"
+    "fmt"
     "goxstream/internal/model"
     "github.com/segmentio/kafka-go"
+    "strconv"
+    "strings"
     "time"
 )
 
@@ -14,7 +17,14 @@ type KafkaSourceConfig struct {
     GroupID string
 }
 
-func KafkaSource(cfg KafkaSourceConfig, out chan<- model.Event) error {
+// KafkaSource consumes a topic as part of a consumer group. Offsets are keyed
+// by "topic/partition". Messages are fetched without committing them: with
+// checkpointing, the group's offsets are committed only once a checkpoint
+// covering them is saved, as reported on committed; without it, each message
+// is committed once it is handed on. A restarted job resumes from its
+// checkpoint, which may be ahead of the group's offsets, so messages at or
+// before a resumed offset are skipped.
+func KafkaSource(ctx context.Context, cfg KafkaSourceConfig, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error {
     r := kafka.NewReader(kafka.ReaderConfig{
         Brokers:  cfg.Brokers,
         GroupID:  cfg.GroupID,
@@ -23,22 +33,79 @@ func KafkaSource(cfg KafkaSourceConfig, out chan<- model.Event) error {
         MaxBytes: 1e6,
     })
     defer r.Close()
-    ctx := context.Background()
+
+    if committed != nil {
+        commitCtx, stop := context.WithCancel(ctx)
+        stopped := make(chan struct{})
+        go func() {
+            defer close(stopped)
+            commitCheckpoints(commitCtx, r, cfg.Topic, committed)
+        }()
+        defer func() {
+            stop()
+            <-stopped
+        }()
+    }
 
     for {
-        m, err := r.ReadMessage(ctx)
+        m, err := r.FetchMessage(ctx)
         if err != nil {
-            break
+            if ctx.Err() != nil {
+                return nil
+            }
+            return fmt.Errorf("kafka read: %w", err)
+        }
+        key := fmt.Sprintf("%s/%d", m.Topic, m.Partition)
+        if last, ok := resume[key]; ok && m.Offset <= last {
+            continue
         }
         var data map[string]interface{}
         if err := json.Unmarshal(m.Value, &data); err != nil {
             continue
         }
-        out <- model.Event{
+        select {
+        case out <- model.Event{
             Data:      data,
-            Timestamp: time.Now(), // or extract from data["timestamp"]
+            Timestamp: time.Now(), // processing time, unless the source has a timestamp block
+            Offset:    &model.Offset{Key: key, Value: m.Offset},
+        }:
+        case <-ctx.Done():
+            return nil
+        }
+        if committed == nil {
+            if err := r.CommitMessages(ctx, m); err != nil && ctx.Err() == nil {
+                return fmt.Errorf("kafka commit: %w", err)
+            }
+        }
+    }
+}
+
+// commitCheckpoints commits the group's offsets of topic as checkpoints are
+// saved, until ctx is cancelled. A failed commit only delays the group's
+// offsets, e.g. after a rebalance, since the checkpoint decides where a
+// restarted job resumes.
+func commitCheckpoints(ctx context.Context, r *kafka.Reader, topic string, committed <-chan model.Offsets) {
+    for {
+        var offsets model.Offsets
+        select {
+        case <-ctx.Done():
+            return
+        case offsets = <-committed:
+        }
+        var msgs []kafka.Message
+        for key, offset := range offsets {
+            t, p, ok := strings.Cut(key, "/")
+            partition, err := strconv.Atoi(p)
+            if !ok || err != nil || t != topic {
+                continue
+            }
+            msgs = append(msgs, kafka.Message{Topic: t, Partition: partition, Offset: offset})
+        }
+        if len(msgs) == 0 {
+            continue
+        }
+        if err := r.CommitMessages(ctx, msgs...); err != nil && ctx.Err() == nil {
+            fmt.Println("kafka commit error:", err)
         }
     }
-    close(out)
-    return nil
 }
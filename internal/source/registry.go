@@ -1,13 +1,23 @@
 package source
 
 import (
+	"context"
 	"fmt"
+	"goxstream/internal/compression"
 	"goxstream/internal/model"
+	"time"
 )
 
 // -------- Source Registry --------
 
-type SourceFactory func(params map[string]interface{}, out chan<- model.Event) error
+// A SourceFactory emits events into out until the input is exhausted or ctx
+// is cancelled. BuildSource closes out once the factory returns. Records at
+// or before the positions in resume were already processed by a previous
+// run and must be skipped; every emitted record carries its own Offset.
+// When the pipeline checkpoints, committed receives the offsets of each
+// checkpoint once it is saved, for sources that acknowledge what they read
+// upstream; it is nil otherwise.
+type SourceFactory func(ctx context.Context, params map[string]interface{}, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error
 
 var registry = map[string]SourceFactory{
 	"file":  fileSourceFactory,
@@ -15,8 +25,11 @@ var registry = map[string]SourceFactory{
 	"kafka": kafkaSourceFactory,
 }
 
-// BuildSource dynamically constructs the source based on JSON spec
-func BuildSource(srcSpec map[string]interface{}, out chan<- model.Event) error {
+// BuildSource dynamically constructs the source based on JSON spec. A
+// "timestamp" block in the spec sets the event time of every record the
+// source emits (see TimestampConfig).
+func BuildSource(ctx context.Context, srcSpec map[string]interface{}, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error {
+	defer close(out)
 	srcType, ok := srcSpec["type"].(string)
 	if !ok {
 		return fmt.Errorf("source missing 'type'")
@@ -25,32 +38,70 @@ func BuildSource(srcSpec map[string]interface{}, out chan<- model.Event) error {
 	if !ok {
 		return fmt.Errorf("unknown source type: %s", srcType)
 	}
-	return factory(srcSpec, out)
+	ts, err := parseTimestampConfig(srcSpec["timestamp"])
+	if err != nil {
+		return err
+	}
+	if ts == nil {
+		return factory(ctx, srcSpec, resume, committed, out)
+	}
+	return ts.stamp(ctx, func(ctx context.Context, out chan<- model.Event) error {
+		return factory(ctx, srcSpec, resume, committed, out)
+	}, out)
 }
 
 // -------- Adapters for each source type --------
 
-// File source expects: { "type": "file", "path": "input.csv" }
-func fileSourceFactory(params map[string]interface{}, out chan<- model.Event) error {
+// File source expects: { "type": "file", "path": "input.csv" }, plus the
+// codec params of its format (see Codec). The path may be a directory or a
+// glob such as "logs/*.csv"; "order": "mtime" reads files oldest first, and
+// "follow": true keeps tailing them, checking every "poll_interval".
+// Compressed files are detected from their extension, or all read with the
+// codec in "compression".
+func fileSourceFactory(ctx context.Context, params map[string]interface{}, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error {
 	path, ok := params["path"].(string)
 	if !ok {
 		return fmt.Errorf("file source expects 'path'")
 	}
-	return FileSource(path, out)
+	cfg := FileSourceConfig{Path: path, Order: "name", Poll: time.Second}
+	if o, ok := params["order"].(string); ok && o != "" {
+		cfg.Order = o
+	}
+	if cfg.Order != "name" && cfg.Order != "mtime" {
+		return fmt.Errorf("file source order must be name or mtime, got %q", cfg.Order)
+	}
+	cfg.Follow, _ = params["follow"].(bool)
+	if p, ok := params["poll_interval"].(string); ok {
+		d, err := time.ParseDuration(p)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid file source poll_interval: %q", p)
+		}
+		cfg.Poll = d
+	}
+	cfg.Compression, _ = params["compression"].(string)
+	if _, err := compression.Detect("", cfg.Compression); err != nil {
+		return err
+	}
+	codec, err := parseCodec(params)
+	if err != nil {
+		return err
+	}
+	cfg.Codec = codec
+	return FileSource(ctx, cfg, resume, out)
 }
 
 // DB source expects: { "type": "db", "dsn": "...", "query": "..." }
-func dbSourceFactory(params map[string]interface{}, out chan<- model.Event) error {
+func dbSourceFactory(ctx context.Context, params map[string]interface{}, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error {
 	dsn, ok1 := params["dsn"].(string)
 	query, ok2 := params["query"].(string)
 	if !ok1 || !ok2 {
 		return fmt.Errorf("db source expects 'dsn' and 'query'")
 	}
-	return DBSource(DBSourceConfig{DSN: dsn, Query: query}, out)
+	return DBSource(ctx, DBSourceConfig{DSN: dsn, Query: query}, resume, out)
 }
 
 // Kafka source expects: { "type": "kafka", "brokers": [...], "topic": "...", "group_id": "..." }
-func kafkaSourceFactory(params map[string]interface{}, out chan<- model.Event) error {
+func kafkaSourceFactory(ctx context.Context, params map[string]interface{}, resume model.Offsets, committed <-chan model.Offsets, out chan<- model.Event) error {
 	brokersIface, ok1 := params["brokers"].([]interface{})
 	topic, ok2 := params["topic"].(string)
 	groupID, ok3 := params["group_id"].(string)
@@ -65,9 +116,9 @@ func kafkaSourceFactory(params map[string]interface{}, out chan<- model.Event) e
 		}
 		brokers[i] = str
 	}
-	return KafkaSource(KafkaSourceConfig{
+	return KafkaSource(ctx, KafkaSourceConfig{
 		Brokers: brokers,
 		Topic:   topic,
 		GroupID: groupID,
-	}, out)
+	}, resume, committed, out)
 }
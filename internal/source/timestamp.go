@@ -0,0 +1,163 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"goxstream/internal/model"
+)
+
+// TimestampConfig sets how a source derives the event time of its records,
+// e.g. { "field": "ts", "format": "unix_ms", "on_error": "drop" }. Any source
+// accepts it as its "timestamp" param.
+type TimestampConfig struct {
+	Field    string
+	Format   string         // rfc3339 (default), unix (seconds), unix_ms, or a Go time layout
+	Location *time.Location // zone of layouts without an offset, and of unix times
+	OnError  string         // processing_time (default), drop or fail
+}
+
+// parseTimestampConfig reads the "timestamp" param of a source. It returns
+// nil if there is none.
+func parseTimestampConfig(v interface{}) (*TimestampConfig, error) {
+	if v == nil {
+		return nil, nil
+	}
+	params, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("timestamp must be an object")
+	}
+	c := &TimestampConfig{Format: "rfc3339", Location: time.UTC, OnError: "processing_time"}
+	c.Field, _ = params["field"].(string)
+	if c.Field == "" {
+		return nil, fmt.Errorf("timestamp expects 'field'")
+	}
+	if f, ok := params["format"].(string); ok && f != "" {
+		c.Format = f
+	}
+	if tz, ok := params["timezone"].(string); ok && tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp timezone: %w", err)
+		}
+		c.Location = loc
+	}
+	if onErr, ok := params["on_error"].(string); ok && onErr != "" {
+		c.OnError = onErr
+	}
+	switch c.OnError {
+	case "processing_time", "drop", "fail":
+	default:
+		return nil, fmt.Errorf("timestamp on_error must be processing_time, drop or fail, got %q", c.OnError)
+	}
+	return c, nil
+}
+
+// Parse converts a field value to a time according to the format.
+func (c *TimestampConfig) Parse(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case []byte: // e.g. numeric and text columns of the DB source
+		v = string(t)
+	}
+	switch c.Format {
+	case "unix", "unix_ms":
+		f, err := number(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if c.Format == "unix_ms" {
+			return time.UnixMilli(int64(f)).In(c.Location), nil
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).In(c.Location), nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%v is not a string", v)
+	}
+	layout := c.Format
+	if layout == "rfc3339" {
+		layout = time.RFC3339
+	}
+	return time.ParseInLocation(layout, strings.TrimSpace(s), c.Location)
+}
+
+func number(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// Extract returns the event time of a record. A record whose time cannot be
+// read gets the processing time, is skipped (ok is false) or fails the
+// source, depending on OnError.
+func (c *TimestampConfig) Extract(data map[string]interface{}) (t time.Time, ok bool, err error) {
+	v, found := data[c.Field]
+	if !found || v == nil {
+		err = fmt.Errorf("missing timestamp field %q", c.Field)
+	} else {
+		t, err = c.Parse(v)
+	}
+	if err == nil {
+		return t, true, nil
+	}
+	switch c.OnError {
+	case "drop":
+		return time.Time{}, false, nil
+	case "fail":
+		return time.Time{}, false, fmt.Errorf("timestamp: %w", err)
+	}
+	return time.Now(), true, nil
+}
+
+// stamp runs a source and replaces the event time of every record it emits.
+func (c *TimestampConfig) stamp(ctx context.Context, run func(ctx context.Context, out chan<- model.Event) error, out chan<- model.Event) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	raw := make(chan model.Event)
+	done := make(chan error, 1)
+	go func() {
+		defer close(raw)
+		done <- run(ctx, raw)
+	}()
+	stop := func(err error) error {
+		cancel()
+		for range raw {
+		}
+		if srcErr := <-done; err == nil {
+			err = srcErr
+		}
+		return err
+	}
+
+	for e := range raw {
+		t, ok, err := c.Extract(e.Data)
+		if err != nil {
+			return stop(err)
+		}
+		if !ok {
+			continue
+		}
+		e.Timestamp = t
+		select {
+		case out <- e:
+		case <-ctx.Done():
+			return stop(nil)
+		}
+	}
+	return <-done
+}